@@ -4,9 +4,11 @@ import (
 	"flag"
 	"os"
 	"strings"
+	"time"
 
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -16,6 +18,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"         // NEW
 
 	"github.com/loft-demos/vcluster-platform-flux-secret-controller/internal/controller"
+	"github.com/loft-demos/vcluster-platform-flux-secret-controller/internal/envelope"
 )
 
 func main() {
@@ -45,6 +48,55 @@ func main() {
 		akType         string
 		akTeam         string
 		akDisplayNameTmpl string
+		sweepInterval  time.Duration
+		phasePolicy    string
+		transitionalPhases string
+		phaseRequeue   time.Duration
+		rotateEvery    time.Duration
+		rotateGrace    time.Duration
+		akTTL          time.Duration
+		akRenewBefore  time.Duration
+		byoSecret      string
+		byoSecretKey   string
+		byoAccessKey   string
+		akUser         string
+		akUserLabel    string
+		akTeamLabel    string
+		akGroupLabels  string
+		akReadOnly     bool
+		akScopeRules   string
+		akAllowedUsers string
+		akAllowedTeams string
+		tokenKeyFile   string
+		tokenPrevKeys  string
+		secretKeys     string
+		kcfgProxyURL   string
+		kcfgTLSName    string
+		kcfgInsecure   bool
+		kcfgNamespace  string
+		kcfgCertFile   string
+		kcfgKeyFile    string
+		kcfgExecCmd    string
+		kcfgExecArgs   string
+		kcfgExecAPI    string
+		kcfgExtensions string
+		kcfgClusterTmpl string
+		kcfgContextTmpl string
+		kcfgUserTmpl   string
+		endpointMode   string
+		localCluster   string
+		outputs        string
+		argoNSPatterns string
+		argoNameTmpl   string
+		capiNSPatterns string
+		capiNameTmpl   string
+		secretTmplFile string
+		bootstrapDir   string
+		inputProviders bool
+		verifyMode     string
+		verifyTimeout  time.Duration
+		verifyRetry    time.Duration
+		verifyMaxRetry time.Duration
 	)
 
 	flag.StringVar(&labelSelector, "selector", "vcluster.com/import-fluxcd=true", "label selector for VCIs")
@@ -52,7 +104,7 @@ func main() {
 	flag.StringVar(&secretPrefix, "secret-name-prefix", "vci-", "prefix for created kubeconfig secret names")
 	flag.StringVar(&serverTmpl, "server-template",
 		"https://{{ .Domain }}/kubernetes/project/{{ .Project }}/virtualcluster/{{ .Name }}",
-		"Go template for kube-apiserver URL (vars: Domain, Project, Namespace, Name)")
+		"Go template for kube-apiserver URL (vars: Domain, Project, Namespace, Name, Cluster, Labels, Annotations, Spec; funcs: lower, replace, default, trimPrefix)")
 	flag.StringVar(&loftDomain, "loft-domain", "beta.us.demo.dev", "Base domain used in --server-template")
 	flag.StringVar(&caSecretNS, "ca-secret-namespace", "", "Namespace of Secret with custom CA PEM (optional)")
 	flag.StringVar(&caSecretName, "ca-secret-name", "", "Secret name containing custom CA PEM (optional)")
@@ -63,6 +115,55 @@ func main() {
 	flag.StringVar(&akType, "accesskey-type", "User", "AccessKey spec.type (User|Other)")
 	flag.StringVar(&akTeam, "accesskey-team", "loft-admins", "AccessKey team (used when type=User)")
 	flag.StringVar(&akDisplayNameTmpl, "accesskey-display-name-template", "flux-{{ .Name }}", "Go template for AccessKey displayName (vars: Name, Project, Namespace)")
+	flag.DurationVar(&sweepInterval, "orphan-sweep-interval", time.Hour, "how often to delete AccessKeys/Secrets whose VCI is gone or unselected (0 = only at startup)")
+	flag.StringVar(&phasePolicy, "phase-policy", "", "comma-separated Phase=action for non-Ready VCIs, action is keep|annotate|withdraw (e.g. 'Sleeping=annotate,Failed=withdraw')")
+	flag.StringVar(&transitionalPhases, "transitional-phases", "Pending,WakingUp,Deleting", "comma-separated VCI phases that are requeued after --phase-requeue-after")
+	flag.DurationVar(&phaseRequeue, "phase-requeue-after", 30*time.Second, "requeue delay for VCIs in a transitional phase (0 disables)")
+	flag.DurationVar(&rotateEvery, "token-rotation-interval", 0, "rotate AccessKey tokens this often (0 disables; VCI annotation vci.flux.loft.sh/token-rotation-interval overrides)")
+	flag.DurationVar(&rotateGrace, "token-rotation-grace", 10*time.Minute, "how long the previous token stays valid after a rotation")
+	flag.DurationVar(&akTTL, "accesskey-ttl", 0, "lifetime of generated AccessKeys (0 = no expiry)")
+	flag.DurationVar(&akRenewBefore, "accesskey-renew-before", 10*time.Minute, "renew and republish a TTL'd AccessKey this long before it expires")
+	flag.StringVar(&byoSecret, "existing-token-secret", "", "use the token from this '[namespace/]name' Secret for all VCIs instead of generating AccessKeys")
+	flag.StringVar(&byoSecretKey, "existing-token-secret-key", "token", "key in --existing-token-secret holding the token")
+	flag.StringVar(&byoAccessKey, "existing-accesskey", "", "use spec.key of this existing AccessKey for all VCIs instead of generating AccessKeys")
+	flag.StringVar(&akUser, "accesskey-user", "", "AccessKey owning user (type=User; takes precedence over --accesskey-team)")
+	flag.StringVar(&akUserLabel, "accesskey-user-from-label", "", "VCI label whose value is used as AccessKey user (e.g. 'owner')")
+	flag.StringVar(&akTeamLabel, "accesskey-team-from-label", "", "VCI label whose value is used as AccessKey team")
+	flag.StringVar(&akGroupLabels, "accesskey-groups-from-labels", "", "comma-separated VCI labels whose values become AccessKey groups (e.g. 'cost-center')")
+	flag.BoolVar(&akReadOnly, "accesskey-read-only", false, "restrict generated AccessKeys to get/list/watch")
+	flag.StringVar(&akScopeRules, "accesskey-scope-rules", "", "JSON list of AccessKey scope rules (verbs, resources, namespaces) replacing the full virtual cluster grant of every generated AccessKey")
+	flag.StringVar(&akAllowedUsers, "accesskey-allowed-users", "", "comma-separated users the vci.flux.loft.sh/accesskey-user annotation may select (empty = annotation rejected)")
+	flag.StringVar(&akAllowedTeams, "accesskey-allowed-teams", "", "comma-separated teams the vci.flux.loft.sh/accesskey-team annotation may select (empty = annotation rejected)")
+	flag.StringVar(&tokenKeyFile, "token-encryption-key-file", "", "file with a 32-byte (raw or base64) key; if set, stored AccessKey tokens are envelope-encrypted")
+	flag.StringVar(&tokenPrevKeys, "token-encryption-previous-key-files", "", "comma-separated files with previous keys, still used to decrypt tokens during key rotation")
+	flag.StringVar(&secretKeys, "secret-keys", "", "comma-separated key=encoding list (json|yaml) for the kubeconfig Secret, e.g. 'value=yaml,value.json=json' (overrides --secret-key)")
+	flag.StringVar(&kcfgProxyURL, "kubeconfig-proxy-url", "", "proxy-url for the kubeconfig cluster")
+	flag.StringVar(&kcfgTLSName, "kubeconfig-tls-server-name", "", "tls-server-name for the kubeconfig cluster")
+	flag.BoolVar(&kcfgInsecure, "kubeconfig-insecure-skip-tls-verify", false, "set insecure-skip-tls-verify on the kubeconfig cluster")
+	flag.StringVar(&kcfgNamespace, "kubeconfig-namespace", "", "default namespace on the kubeconfig context")
+	flag.StringVar(&kcfgCertFile, "kubeconfig-client-cert-file", "", "PEM client certificate embedded as client-certificate-data")
+	flag.StringVar(&kcfgKeyFile, "kubeconfig-client-key-file", "", "PEM client key embedded as client-key-data")
+	flag.StringVar(&kcfgExecCmd, "kubeconfig-exec-command", "", "exec credential plugin command for the kubeconfig user")
+	flag.StringVar(&kcfgExecArgs, "kubeconfig-exec-args", "", "comma-separated args for --kubeconfig-exec-command")
+	flag.StringVar(&kcfgExecAPI, "kubeconfig-exec-api-version", "client.authentication.k8s.io/v1", "apiVersion for --kubeconfig-exec-command")
+	flag.StringVar(&kcfgExtensions, "kubeconfig-extensions", "", "JSON object of extension name -> value added to cluster, context and user")
+	flag.StringVar(&kcfgClusterTmpl, "kubeconfig-cluster-name-template", "loft", "Go template for the kubeconfig cluster name (vars: Name, Project, Namespace, Domain, Labels)")
+	flag.StringVar(&kcfgContextTmpl, "kubeconfig-context-name-template", "loft", "Go template for the kubeconfig context name (vars: Name, Project, Namespace, Domain, Labels)")
+	flag.StringVar(&kcfgUserTmpl, "kubeconfig-user-name-template", "loft", "Go template for the kubeconfig user name (vars: Name, Project, Namespace, Domain, Labels)")
+	flag.StringVar(&endpointMode, "endpoint-mode", "proxy", "kubeconfig server: 'proxy' (platform, --server-template) or 'direct' (vCluster ingress, exported or LoadBalancer endpoint, falls back to proxy)")
+	flag.StringVar(&localCluster, "local-cluster", "", "platform name of the cluster this controller runs on; direct mode only reads vc-<name> Secrets of VCIs on that cluster (empty = assume all)")
+	flag.StringVar(&outputs, "outputs", controller.OutputFlux, "comma-separated Secret formats to publish: flux, argocd, capi")
+	flag.StringVar(&argoNSPatterns, "argocd-namespaces", "argocd", "comma-separated Argo CD namespace patterns for cluster Secrets (globs OK)")
+	flag.StringVar(&argoNameTmpl, "argocd-cluster-name-template", "{{ .Project }}-{{ .Name }}", "Go template for the Argo CD cluster name (vars: Name, Project, Namespace, Domain, Labels)")
+	flag.StringVar(&capiNSPatterns, "capi-namespaces", "default", "comma-separated namespace patterns for Cluster API '<cluster>-kubeconfig' Secrets (globs OK)")
+	flag.StringVar(&capiNameTmpl, "capi-cluster-name-template", "{{ .Project }}-{{ .Name }}", "Go template for the Cluster API cluster name (vars: Name, Project, Namespace, Domain, Labels)")
+	flag.StringVar(&secretTmplFile, "secret-template-file", "", "YAML file (e.g. a mounted ConfigMap) with name, type, labels, annotations and data templates for the Flux Secret")
+	flag.StringVar(&bootstrapDir, "bootstrap-template-dir", "", "directory (e.g. a mounted ConfigMap) of Flux Kustomization/HelmRelease templates, selected per VCI with the vci.flux.loft.sh/bootstrap-template annotation")
+	flag.BoolVar(&inputProviders, "input-providers", false, "publish a Flux Operator ResourceSetInputProvider (type Static) next to each Flux Secret, for ResourceSet inputsFrom")
+	flag.StringVar(&verifyMode, "verify-mode", controller.VerifyOff, "check each kubeconfig with a SelfSubjectReview before publishing: 'off', 'label' (publish and set vci.flux.loft.sh/verified) or 'gate' (publish only once verified)")
+	flag.DurationVar(&verifyTimeout, "verify-timeout", 5*time.Second, "timeout for the kubeconfig verification call")
+	flag.DurationVar(&verifyRetry, "verify-retry-after", 10*time.Second, "requeue delay after a failed verification, doubled per consecutive failure")
+	flag.DurationVar(&verifyMaxRetry, "verify-max-retry-after", 10*time.Minute, "upper bound for --verify-retry-after backoff")
 
 	flag.Parse()
 
@@ -82,12 +183,106 @@ func main() {
 
 	log := crlog.Log.WithName("setup")
 
+	phases, err := controller.ParsePhasePolicy(phasePolicy)
+	if err != nil {
+		panic(err)
+	}
+
+	serverTemplate, err := controller.ParseServerTemplate(serverTmpl)
+	if err != nil {
+		panic(err)
+	}
+
+	if !controller.ValidEndpointMode(endpointMode) {
+		panic("--endpoint-mode must be 'proxy' or 'direct'")
+	}
+
+	if !controller.ValidVerifyMode(verifyMode) {
+		panic("--verify-mode must be 'off', 'label' or 'gate'")
+	}
+
+	var secretTemplate *controller.SecretTemplate
+	if secretTmplFile != "" {
+		if secretTemplate, err = controller.LoadSecretTemplate(secretTmplFile); err != nil {
+			panic(err)
+		}
+	}
+
+	if _, err := controller.ParseScopeRules(akScopeRules); err != nil {
+		panic(err)
+	}
+
+	var bootstrapTemplates controller.BootstrapTemplates
+	if bootstrapDir != "" {
+		if bootstrapTemplates, err = controller.LoadBootstrapTemplates(bootstrapDir); err != nil {
+			panic(err)
+		}
+	}
+
+	outputList, err := controller.ParseOutputs(outputs)
+	if err != nil {
+		panic(err)
+	}
+
+	outKeys, err := controller.ParseOutputKeys(secretKeys)
+	if err != nil {
+		panic(err)
+	}
+
+	kcfgOpts := controller.KubeconfigOptions{
+		ProxyURL:              kcfgProxyURL,
+		TLSServerName:         kcfgTLSName,
+		InsecureSkipTLSVerify: kcfgInsecure,
+		Namespace:             kcfgNamespace,
+	}
+	if kcfgCertFile != "" {
+		if kcfgOpts.ClientCertificateData, err = os.ReadFile(kcfgCertFile); err != nil {
+			panic(err)
+		}
+	}
+	if kcfgKeyFile != "" {
+		if kcfgOpts.ClientKeyData, err = os.ReadFile(kcfgKeyFile); err != nil {
+			panic(err)
+		}
+	}
+	if kcfgExecCmd != "" {
+		kcfgOpts.Exec = &clientcmdapi.ExecConfig{
+			Command:         kcfgExecCmd,
+			Args:            splitNonEmpty(kcfgExecArgs),
+			APIVersion:      kcfgExecAPI,
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		}
+	}
+	if kcfgOpts.Extensions, err = controller.ParseKubeconfigExtensions(kcfgExtensions); err != nil {
+		panic(err)
+	}
+	if err := controller.ValidateKubeconfigOptions(kcfgOpts); err != nil {
+		panic(err)
+	}
+
+	var tokenKeys envelope.KeyProvider
+	if tokenKeyFile != "" {
+		kp, err := envelope.NewFileKeyProvider(tokenKeyFile)
+		if err != nil {
+			panic(err)
+		}
+		var prev []envelope.KeyProvider
+		for _, f := range splitNonEmpty(tokenPrevKeys) {
+			pk, err := envelope.NewFileKeyProvider(f)
+			if err != nil {
+				panic(err)
+			}
+			prev = append(prev, pk)
+		}
+		tokenKeys = envelope.NewKeyRing(kp, prev...)
+	}
+
 	opts := controller.Options{
 		LabelSelector:         labelSelector,
 		SecretKey:             secretKey,
 		SecretPrefix:          secretPrefix,
 		LoftDomain:            loftDomain,
-		ServerTemplate:        serverTmpl,
+		ServerTemplate:        serverTemplate,
 		CASecretNS:            caSecretNS,
 		CASecretName:          caSecretName,
 		CASecretKey:           caSecretKey,
@@ -96,8 +291,53 @@ func main() {
 		PassthroughPrefixes:   strings.Split(passthroughLbls, ","),
 		AccessKeyType: akType,
 		AccessKeyTeam: akTeam,
+		PhasePolicy:        phases,
+		TransitionalPhases: strings.Split(transitionalPhases, ","),
+		PhaseRequeueAfter:  phaseRequeue,
+		TokenRotationInterval: rotateEvery,
+		TokenRotationGrace:    rotateGrace,
+		AccessKeyTTL:          akTTL,
+		AccessKeyRenewBefore:  akRenewBefore,
+		ExistingTokenSecret:    byoSecret,
+		ExistingTokenSecretKey: byoSecretKey,
+		ExistingAccessKey:      byoAccessKey,
+		AccessKeyUser:             akUser,
+		AccessKeyUserFromLabel:    akUserLabel,
+		AccessKeyTeamFromLabel:    akTeamLabel,
+		AccessKeyGroupsFromLabels: splitNonEmpty(akGroupLabels),
+		AccessKeyReadOnly:         akReadOnly,
+		AccessKeyScopeRules:       akScopeRules,
+		AccessKeyAllowedUsers:     splitNonEmpty(akAllowedUsers),
+		AccessKeyAllowedTeams:     splitNonEmpty(akAllowedTeams),
+		TokenKeyProvider:          tokenKeys,
+		OutputKeys:                outKeys,
+		Kubeconfig:                kcfgOpts,
+		ClusterNameTmpl:           kcfgClusterTmpl,
+		ContextNameTmpl:           kcfgContextTmpl,
+		UserNameTmpl:              kcfgUserTmpl,
+		EndpointMode:              endpointMode,
+		LocalClusterName:          localCluster,
+		Outputs:                   outputList,
+		ArgoCDNamespacePatterns:   strings.Split(argoNSPatterns, ","),
+		ArgoCDClusterNameTmpl:     argoNameTmpl,
+		CAPINamespacePatterns:     strings.Split(capiNSPatterns, ","),
+		CAPIClusterNameTmpl:       capiNameTmpl,
+		SecretTemplate:            secretTemplate,
+		BootstrapTemplates:        bootstrapTemplates,
+		InputProviders:            inputProviders,
+		VerifyMode:                verifyMode,
+		VerifyTimeout:             verifyTimeout,
+		VerifyRetryAfter:          verifyRetry,
+		VerifyMaxRetryAfter:       verifyMaxRetry,
+	}
+	rec := controller.NewVciReconciler(mgr.GetClient(), log, opts)
+	if err := rec.SetupWithManager(mgr); err != nil {
+		panic(err)
 	}
-	if err := controller.NewVciReconciler(mgr.GetClient(), log, opts).SetupWithManager(mgr); err != nil {
+	if err := rec.SetupOrphanSweeper(mgr, sweepInterval); err != nil {
+		panic(err)
+	}
+	if err := rec.SetupTokenSecretMigration(mgr); err != nil {
 		panic(err)
 	}
 
@@ -106,3 +346,14 @@ func main() {
 	}
 	_ = os.Stdout.Sync()
 }
+
+// splitNonEmpty splits a comma-separated flag, dropping empty entries.
+func splitNonEmpty(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
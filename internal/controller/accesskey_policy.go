@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/loft-demos/vcluster-platform-flux-secret-controller/internal/util"
+)
+
+// Per-VCI annotations overriding the --accesskey-* policy flags. User and team
+// overrides must be allow-listed; read-only and scope rules may only narrow.
+const (
+	annAccessKeyUser       = "vci.flux.loft.sh/accesskey-user"
+	annAccessKeyTeam       = "vci.flux.loft.sh/accesskey-team"
+	annAccessKeyReadOnly   = "vci.flux.loft.sh/accesskey-read-only"
+	annAccessKeyScopeRules = "vci.flux.loft.sh/accesskey-scope-rules"
+)
+
+// scopeRuleFields are the storage.loft.sh AccessKeyScopeRule fields a user rule
+// may set. cluster, virtualClusters and requestTargets are set by the controller
+// to pin each rule to the VCI's own virtual cluster.
+var scopeRuleFields = []string{"verbs", "resources", "namespaces"}
+
+// readOnlyVerbs are the verbs a read-only AccessKey keeps.
+var readOnlyVerbs = []string{"get", "list", "watch"}
+
+// accessKeyPolicy is the identity and scope applied to a generated AccessKey.
+type accessKeyPolicy struct {
+	User     string
+	Team     string
+	Groups   []string
+	ReadOnly bool
+	Rules    []map[string]any // storage.loft.sh AccessKeyScopeRule objects (verbs, resources, namespaces)
+}
+
+// vcTarget identifies the virtual cluster a generated AccessKey is pinned to.
+type vcTarget struct {
+	Project        string
+	VirtualCluster string // VCI name, used by scope.virtualClusters
+	Name           string // vCluster name on the host cluster
+	Cluster        string // host (connected) cluster
+	HostNamespace  string // namespace of the vCluster on the host cluster
+}
+
+// vcTargetFor reads the pin target from the VCI's spec.clusterRef.
+func vcTargetFor(vci *unstructured.Unstructured) vcTarget {
+	t := vcTarget{
+		Project:        projectFromNamespace(vci.GetNamespace()),
+		VirtualCluster: vci.GetName(),
+		Name:           util.GetString(vci, "spec", "clusterRef", "virtualCluster"),
+		Cluster:        util.GetString(vci, "spec", "clusterRef", "cluster"),
+		HostNamespace:  util.GetString(vci, "spec", "clusterRef", "namespace"),
+	}
+	if t.Name == "" {
+		t.Name = vci.GetName()
+	}
+	return t
+}
+
+// ParseScopeRules parses a JSON list of AccessKey scope rules and rejects fields
+// other than verbs, resources and namespaces.
+func ParseScopeRules(s string) ([]map[string]any, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var rules []map[string]any
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("parse AccessKey scope rules: %w", err)
+	}
+	for i, rule := range rules {
+		for k := range rule {
+			if !slices.Contains(scopeRuleFields, k) {
+				return nil, fmt.Errorf("AccessKey scope rule %d: field %q not allowed (want %s)", i, k, strings.Join(scopeRuleFields, ", "))
+			}
+		}
+	}
+	return rules, nil
+}
+
+// accessKeyPolicyFor resolves the policy for a VCI. Precedence per field:
+// VCI annotation, then VCI label named by --accesskey-*-from-label, then flag.
+// Annotations can only pick an allow-listed user or team, and can only make
+// the key narrower: read-only can be switched on, not off, and scope rules are
+// only accepted when --accesskey-scope-rules is unset.
+func (r *VciReconciler) accessKeyPolicyFor(vci *unstructured.Unstructured) (accessKeyPolicy, error) {
+	ann := vci.GetAnnotations()
+	lbl := vci.GetLabels()
+
+	pick := func(annKey, fromLabel, def string, allowed []string) (string, error) {
+		if v := ann[annKey]; v != "" {
+			if !slices.Contains(allowed, v) {
+				return "", fmt.Errorf("annotation %s=%q is not in the allowed list", annKey, v)
+			}
+			return v, nil
+		}
+		if fromLabel != "" && lbl[fromLabel] != "" {
+			return lbl[fromLabel], nil
+		}
+		return def, nil
+	}
+
+	p := accessKeyPolicy{ReadOnly: r.Opts.AccessKeyReadOnly}
+	var err error
+	if p.User, err = pick(annAccessKeyUser, r.Opts.AccessKeyUserFromLabel, r.Opts.AccessKeyUser, r.Opts.AccessKeyAllowedUsers); err != nil {
+		return p, err
+	}
+	if p.Team, err = pick(annAccessKeyTeam, r.Opts.AccessKeyTeamFromLabel, r.Opts.AccessKeyTeam, r.Opts.AccessKeyAllowedTeams); err != nil {
+		return p, err
+	}
+	for _, k := range r.Opts.AccessKeyGroupsFromLabels {
+		if v := lbl[strings.TrimSpace(k)]; v != "" {
+			p.Groups = append(p.Groups, v)
+		}
+	}
+	if v, ok := ann[annAccessKeyReadOnly]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("annotation %s: %w", annAccessKeyReadOnly, err)
+		}
+		if !b && r.Opts.AccessKeyReadOnly {
+			return p, fmt.Errorf("annotation %s=false cannot override --accesskey-read-only", annAccessKeyReadOnly)
+		}
+		p.ReadOnly = b
+	}
+
+	rules := r.Opts.AccessKeyScopeRules
+	if v := ann[annAccessKeyScopeRules]; v != "" {
+		// rules are alternatives, so replacing the global list could widen the key
+		if rules != "" {
+			return p, fmt.Errorf("annotation %s cannot override --accesskey-scope-rules", annAccessKeyScopeRules)
+		}
+		rules = v
+	}
+	if p.Rules, err = ParseScopeRules(rules); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// applyTo sets identity and scope on an AccessKey spec. A user takes precedence
+// over a team; identity is only set for type User.
+//
+// Scope rules are alternatives to the scope.virtualClusters grant, not filters on
+// it, so with read-only or scope rules the grant is replaced: every rule is
+// pinned to the virtual cluster (cluster, virtualClusters, requestTargets) and,
+// in read-only mode, its verbs are cut down to get/list/watch.
+func (p accessKeyPolicy) applyTo(spec map[string]any, akType string, target vcTarget) error {
+	if strings.EqualFold(akType, "User") {
+		switch {
+		case p.User != "":
+			spec["user"] = p.User
+		case p.Team != "":
+			spec["team"] = p.Team
+		}
+	}
+	if len(p.Groups) > 0 {
+		groups := make([]any, 0, len(p.Groups))
+		for _, g := range p.Groups {
+			groups = append(groups, g)
+		}
+		spec["groups"] = groups
+	}
+
+	scope, _ := spec["scope"].(map[string]any)
+	if scope == nil {
+		scope = map[string]any{}
+		spec["scope"] = scope
+	}
+	if !p.ReadOnly && len(p.Rules) == 0 {
+		return nil
+	}
+	if target.HostNamespace == "" {
+		return fmt.Errorf("cannot scope AccessKey rules: VCI has no spec.clusterRef.namespace")
+	}
+
+	src := p.Rules
+	if len(src) == 0 {
+		src = []map[string]any{{}} // read-only on everything in the virtual cluster
+	}
+	rules := make([]any, 0, len(src))
+	for _, in := range src {
+		rule := map[string]any{}
+		for k, v := range in {
+			rule[k] = v
+		}
+		if p.ReadOnly {
+			verbs := readOnlyVerbsOf(rule["verbs"])
+			if len(verbs) == 0 {
+				continue // rule only granted mutating verbs
+			}
+			rule["verbs"] = verbs
+		}
+		rule["requestTargets"] = []any{"VirtualCluster"}
+		if target.Cluster != "" {
+			rule["cluster"] = target.Cluster
+		}
+		rule["virtualClusters"] = []any{
+			map[string]any{"name": target.Name, "namespace": target.HostNamespace},
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		// an empty scope would not restrict the key at all
+		return fmt.Errorf("no AccessKey scope rule allows a read-only verb")
+	}
+	delete(scope, "virtualClusters")
+	scope["rules"] = rules
+	return nil
+}
+
+// readOnlyVerbsOf intersects a rule's verbs with get/list/watch; no verbs or "*"
+// means all verbs.
+func readOnlyVerbsOf(v any) []any {
+	list, _ := v.([]any)
+	all := len(list) == 0
+	have := map[string]bool{}
+	for _, x := range list {
+		s, _ := x.(string)
+		if s == "*" {
+			all = true
+		}
+		have[s] = true
+	}
+	var out []any
+	for _, verb := range readOnlyVerbs {
+		if all || have[verb] {
+			out = append(out, verb)
+		}
+	}
+	return out
+}
+
+// scopeRulesPinned reports whether every rule stored on an AccessKey still
+// carries its virtualClusters pin. The AccessKey CRD prunes unknown fields, so a
+// platform without rule-level virtualClusters would otherwise turn a pinned key
+// into one valid for everything its owner can reach.
+func scopeRulesPinned(ak *unstructured.Unstructured) bool {
+	rules, _, _ := unstructured.NestedSlice(ak.Object, "spec", "scope", "rules")
+	for _, x := range rules {
+		rule, _ := x.(map[string]any)
+		if vcs, _ := rule["virtualClusters"].([]any); len(vcs) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// argoCDOutput is an Argo CD declarative cluster Secret
+// (argocd.argoproj.io/secret-type=cluster) carrying the same server, token and
+// CA as the Flux kubeconfig.
+type argoCDOutput struct{ r *VciReconciler }
+
+// argoClusterConfig is the "config" field of an Argo CD cluster Secret.
+type argoClusterConfig struct {
+	BearerToken        string                  `json:"bearerToken,omitempty"`
+	TLSClientConfig    argoTLSClientConfig     `json:"tlsClientConfig"`
+	ExecProviderConfig *argoExecProviderConfig `json:"execProviderConfig,omitempty"`
+}
+
+type argoTLSClientConfig struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+	CertData   []byte `json:"certData,omitempty"`
+	KeyData    []byte `json:"keyData,omitempty"`
+}
+
+type argoExecProviderConfig struct {
+	Command    string            `json:"command"`
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	APIVersion string            `json:"apiVersion,omitempty"`
+}
+
+func (argoCDOutput) Name() string { return OutputArgoCD }
+
+func (a argoCDOutput) Namespaces(ctx context.Context) ([]string, error) {
+	return a.r.resolveNamespacePatterns(ctx, a.r.Opts.ArgoCDNamespacePatterns, "argocd")
+}
+
+func (a argoCDOutput) MatchesNamespace(ns string) bool {
+	return matchesNamespacePatterns(a.r.Opts.ArgoCDNamespacePatterns, "argocd", ns)
+}
+
+func (a argoCDOutput) Render(in *publishInput) (secretOutput, error) {
+	clusterName, err := renderKubeconfigName(a.r.Opts.ArgoCDClusterNameTmpl, a.r.nameVarsFor(in.VCI))
+	if err != nil {
+		return secretOutput{}, fmt.Errorf("render Argo CD cluster name: %w", err)
+	}
+
+	opts := in.Kubeconfig
+	cfg := argoClusterConfig{
+		BearerToken: in.Token,
+		TLSClientConfig: argoTLSClientConfig{
+			Insecure:   opts.InsecureSkipTLSVerify,
+			ServerName: opts.TLSServerName,
+			CertData:   opts.ClientCertificateData,
+			KeyData:    opts.ClientKeyData,
+		},
+	}
+	if !opts.InsecureSkipTLSVerify {
+		cfg.TLSClientConfig.CAData = in.CAPEM
+	}
+	if opts.Exec != nil {
+		env := map[string]string{}
+		for _, e := range opts.Exec.Env {
+			env[e.Name] = e.Value
+		}
+		cfg.ExecProviderConfig = &argoExecProviderConfig{
+			Command:    opts.Exec.Command,
+			Args:       opts.Exec.Args,
+			Env:        env,
+			APIVersion: opts.Exec.APIVersion,
+		}
+	}
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return secretOutput{}, fmt.Errorf("encode Argo CD cluster config: %w", err)
+	}
+
+	return secretOutput{
+		Name: fmt.Sprintf("%s%s-%s-argocd", a.r.Opts.SecretPrefix, in.Project, in.VCI.GetName()),
+		Type: corev1.SecretTypeOpaque,
+		Labels: map[string]string{
+			"argocd.argoproj.io/secret-type": "cluster",
+		},
+		Data: map[string][]byte{
+			"name":   []byte(clusterName),
+			"server": []byte(in.Server),
+			"config": cfgJSON,
+		},
+	}, nil
+}
@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// annBootstrapTemplate on a VCI names the bootstrap template (a file in
+// --bootstrap-template-dir, without .yaml) rendered for it.
+const annBootstrapTemplate = "vci.flux.loft.sh/bootstrap-template"
+
+// bootstrapDeleteTimeout bounds how long a revoke waits for bootstrap objects
+// to finish deleting (HelmRelease uninstall, Kustomization prune) before the
+// kubeconfig Secrets they need are deleted anyway.
+const bootstrapDeleteTimeout = 10 * time.Minute
+
+// errNotOwned means an object with the rendered name exists but belongs to the
+// user or another VCI; it is left alone.
+var errNotOwned = errors.New("object exists and is not owned by this VCI")
+
+// Flux kinds a bootstrap template may render. They are handled as unstructured
+// objects, so the controller has no Flux module dependency.
+var (
+	gvkKustomization = schema.GroupVersionKind{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Kind: "Kustomization"}
+	gvkHelmRelease   = schema.GroupVersionKind{Group: "helm.toolkit.fluxcd.io", Version: "v2", Kind: "HelmRelease"}
+	bootstrapKinds   = []schema.GroupVersionKind{gvkKustomization, gvkHelmRelease}
+)
+
+// bootstrapVars is the context for bootstrap templates: the --server-template
+// variables plus the kubeconfig Secret the objects should reference.
+type bootstrapVars struct {
+	serverVars
+	SecretName      string // Flux kubeconfig Secret in TargetNamespace
+	SecretKey       string // data key holding the kubeconfig
+	TargetNamespace string // Flux namespace the objects are created in
+}
+
+// BootstrapTemplates are parsed bootstrap templates by name.
+type BootstrapTemplates map[string]*template.Template
+
+// LoadBootstrapTemplates parses every *.yaml / *.yml file in dir (typically a
+// mounted ConfigMap) and renders each once against sample values, so only
+// Kustomization and HelmRelease objects with a name can be produced.
+func LoadBootstrapTemplates(dir string) (BootstrapTemplates, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read bootstrap templates: %w", err)
+	}
+	out := BootstrapTemplates{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read bootstrap template %s: %w", e.Name(), err)
+		}
+		name := strings.TrimSuffix(e.Name(), ext)
+		t, err := template.New(name).Funcs(serverTemplateFuncs).Option("missingkey=zero").Parse(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("parse bootstrap template %s: %w", e.Name(), err)
+		}
+		sample := bootstrapVars{
+			serverVars: sampleServerVars(),
+			SecretName: "vci-default-vcluster-kubeconfig", SecretKey: "value", TargetNamespace: "flux-system",
+		}
+		if _, err := renderBootstrapObjects(t, sample); err != nil && !isMissingPathError(err) {
+			return nil, fmt.Errorf("bootstrap template %s: %w", e.Name(), err)
+		}
+		out[name] = t
+	}
+	return out, nil
+}
+
+// renderBootstrapObjects renders a (multi-document) template into Flux objects,
+// placed in vars.TargetNamespace.
+func renderBootstrapObjects(t *template.Template, vars bootstrapVars) ([]*unstructured.Unstructured, error) {
+	out, err := executeTemplate(t, vars)
+	if err != nil {
+		return nil, err
+	}
+	dec := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(out), 4096)
+	var objs []*unstructured.Unstructured
+	for {
+		u := &unstructured.Unstructured{}
+		if err := dec.Decode(&u.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("decode rendered object: %w", err)
+		}
+		if len(u.Object) == 0 {
+			continue
+		}
+		gk := u.GroupVersionKind().GroupKind()
+		if gk != gvkKustomization.GroupKind() && gk != gvkHelmRelease.GroupKind() {
+			return nil, fmt.Errorf("rendered %s %q: only Kustomization and HelmRelease are allowed", u.GetKind(), u.GetName())
+		}
+		if u.GetName() == "" {
+			return nil, fmt.Errorf("rendered %s has no metadata.name", u.GetKind())
+		}
+		u.SetNamespace(vars.TargetNamespace)
+		objs = append(objs, u)
+	}
+	return objs, nil
+}
+
+// bootstrapObjectKey identifies a rendered object for pruning.
+func bootstrapObjectKey(u *unstructured.Unstructured) string {
+	return u.GroupVersionKind().GroupKind().String() + "/" + u.GetNamespace() + "/" + u.GetName()
+}
+
+// reconcileBootstrap renders the VCI's bootstrap template into every namespace
+// holding its Flux kubeconfig Secret, then prunes objects it no longer renders.
+// secretNS maps each Flux namespace to the Secret published there. Objects of
+// the same name that we don't own are skipped with a BootstrapConflict event.
+func (r *VciReconciler) reconcileBootstrap(ctx context.Context, vci *unstructured.Unstructured, secretNS map[string]fluxSecretRef) (int, error) {
+	keep := map[string]struct{}{}
+	tmplName := vci.GetAnnotations()[annBootstrapTemplate]
+	if tmplName != "" && len(secretNS) > 0 {
+		t, ok := r.Opts.BootstrapTemplates[tmplName]
+		if !ok {
+			return 0, fmt.Errorf("unknown bootstrap template %q", tmplName)
+		}
+		namespaces := make([]string, 0, len(secretNS))
+		for ns := range secretNS {
+			namespaces = append(namespaces, ns)
+		}
+		sort.Strings(namespaces)
+		for _, ns := range namespaces {
+			ref := secretNS[ns]
+			if ref.Key == "" {
+				return 0, fmt.Errorf("secret template has several data keys and no kubeconfigKey; bootstrap objects cannot reference %s/%s", ns, ref.Name)
+			}
+			objs, err := renderBootstrapObjects(t, bootstrapVars{
+				serverVars:      r.serverVarsFor(vci),
+				SecretName:      ref.Name,
+				SecretKey:       ref.Key,
+				TargetNamespace: ns,
+			})
+			if err != nil {
+				return 0, fmt.Errorf("render bootstrap template %q: %w", tmplName, err)
+			}
+			for _, o := range objs {
+				err := r.upsertBootstrapObject(ctx, vci, o)
+				if errors.Is(err, errNotOwned) {
+					r.Recorder.Eventf(vci, corev1.EventTypeWarning, "BootstrapConflict",
+						"%s %s/%s already exists and is not managed for this VCI; leaving it alone", o.GetKind(), o.GetNamespace(), o.GetName())
+					continue
+				}
+				if err != nil {
+					return 0, fmt.Errorf("upsert %s %s/%s: %w", o.GetKind(), o.GetNamespace(), o.GetName(), err)
+				}
+				keep[bootstrapObjectKey(o)] = struct{}{}
+			}
+		}
+	}
+	return r.deleteBootstrapObjects(ctx, vci.GetNamespace(), vci.GetName(), keep)
+}
+
+// upsertBootstrapObject creates or updates one rendered object with our ownership
+// labels and the VCI's propagated labels. Only spec, labels and annotations are
+// reconciled. An existing object we don't own for this VCI returns errNotOwned.
+func (r *VciReconciler) upsertBootstrapObject(ctx context.Context, vci *unstructured.Unstructured, want *unstructured.Unstructured) error {
+	lbl := r.copyAllVCILabels(vci.GetLabels())
+	for k, v := range want.GetLabels() {
+		lbl[k] = v
+	}
+	lbl["app.kubernetes.io/managed-by"] = "vcluster-platform-flux-secret-controller"
+	lbl["vci.flux.loft.sh/name"] = vci.GetName()
+	lbl["vci.flux.loft.sh/namespace"] = vci.GetNamespace()
+	lbl["vci.flux.loft.sh/project"] = projectFromNamespace(vci.GetNamespace())
+	want.SetLabels(lbl)
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(want.GroupVersionKind())
+	err := r.Get(ctx, client.ObjectKeyFromObject(want), existing)
+	if apierrors.IsNotFound(err) {
+		return r.Create(ctx, want)
+	}
+	if err != nil {
+		return err
+	}
+	if !ownedBy(existing, vci.GetNamespace(), vci.GetName()) {
+		return errNotOwned
+	}
+
+	before := existing.DeepCopy()
+	if spec, ok := want.Object["spec"]; ok {
+		existing.Object["spec"] = spec
+	}
+	cur := existing.GetLabels()
+	if cur == nil {
+		cur = map[string]string{}
+	}
+	for k, v := range lbl {
+		cur[k] = v
+	}
+	existing.SetLabels(cur)
+	ann := existing.GetAnnotations()
+	for k, v := range want.GetAnnotations() {
+		if ann == nil {
+			ann = map[string]string{}
+		}
+		ann[k] = v
+	}
+	existing.SetAnnotations(ann)
+	// skip no-op updates so they don't feed back into our own watch
+	if equality.Semantic.DeepEqual(before.Object, existing.Object) {
+		return nil
+	}
+	return r.Update(ctx, existing)
+}
+
+// deleteBootstrapObjects deletes the VCI's Kustomizations and HelmReleases that
+// are not in keep (nil deletes all). Returns the number of objects deleted or
+// still finalizing; objects finalizing for longer than bootstrapDeleteTimeout
+// are no longer counted.
+func (r *VciReconciler) deleteBootstrapObjects(ctx context.Context, vciNamespace, vciName string, keep map[string]struct{}) (int, error) {
+	if len(r.Opts.BootstrapTemplates) == 0 {
+		return 0, nil // Flux CRDs may not be installed; nothing was ever rendered
+	}
+	sel := labels.SelectorFromSet(map[string]string{
+		"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
+		"vci.flux.loft.sh/name":        vciName,
+		"vci.flux.loft.sh/namespace":   vciNamespace,
+	})
+	pending := 0
+	var errs []error
+	for _, gvk := range bootstrapKinds {
+		var list unstructured.UnstructuredList
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.List(ctx, &list, &client.ListOptions{LabelSelector: sel}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for i := range list.Items {
+			o := &list.Items[i]
+			if _, ok := keep[bootstrapObjectKey(o)]; ok {
+				continue
+			}
+			if ts := o.GetDeletionTimestamp(); ts != nil {
+				if time.Since(ts.Time) < bootstrapDeleteTimeout {
+					pending++
+				}
+				continue
+			}
+			if err := r.Delete(ctx, o); client.IgnoreNotFound(err) != nil {
+				errs = append(errs, err)
+				continue
+			}
+			pending++
+		}
+	}
+	return pending, utilerrors.NewAggregate(errs)
+}
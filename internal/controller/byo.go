@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// VCI annotations selecting bring-your-own credentials. When either is set the
+// controller only renders and distributes the kubeconfig.
+const (
+	// annExistingTokenSecret is "[namespace/]name" of a Secret holding the token
+	// (namespace defaults to --controller-namespace).
+	annExistingTokenSecret = "vci.flux.loft.sh/token-secret"
+	// annExistingTokenSecretKey is the data key in that Secret (default "token").
+	annExistingTokenSecretKey = "vci.flux.loft.sh/token-secret-key"
+	// annExistingAccessKey is the name of an AccessKey whose spec.key is used.
+	annExistingAccessKey = "vci.flux.loft.sh/accesskey"
+
+	// lblBYOAllowed=true on a Secret or AccessKey allows VCI annotations to
+	// reference it. References set by the global flags need no opt-in.
+	lblBYOAllowed = "vci.flux.loft.sh/byo-allowed"
+)
+
+// byoRef points at an externally managed credential.
+type byoRef struct {
+	SecretNS, SecretName, SecretKey string
+	AccessKey                       string
+	FromAnnotation                  bool // set by a VCI annotation rather than a global flag
+}
+
+// matches reports whether o is the Secret or AccessKey this reference points at.
+func (ref byoRef) matches(o client.Object) bool {
+	if _, isSecret := o.(*corev1.Secret); isSecret {
+		return ref.SecretName != "" && o.GetNamespace() == ref.SecretNS && o.GetName() == ref.SecretName
+	}
+	return ref.SecretName == "" && ref.AccessKey != "" && o.GetName() == ref.AccessKey
+}
+
+// byoRefFor returns the VCI's bring-your-own reference (annotation first, then
+// global flags), or false if the controller should manage the AccessKey itself.
+func (r *VciReconciler) byoRefFor(vci *unstructured.Unstructured) (byoRef, bool) {
+	ann := vci.GetAnnotations()
+	secret, key, ak := ann[annExistingTokenSecret], ann[annExistingTokenSecretKey], ann[annExistingAccessKey]
+	fromAnn := secret != "" || ak != ""
+	if !fromAnn {
+		secret, key, ak = r.Opts.ExistingTokenSecret, r.Opts.ExistingTokenSecretKey, r.Opts.ExistingAccessKey
+	}
+	if secret == "" && ak == "" {
+		return byoRef{}, false
+	}
+	ref := byoRef{AccessKey: ak, SecretKey: key, FromAnnotation: fromAnn}
+	if secret != "" {
+		ref.SecretNS, ref.SecretName = r.Opts.ControllerNamespace, secret
+		if ns, name, ok := strings.Cut(secret, "/"); ok {
+			ref.SecretNS, ref.SecretName = ns, name
+		}
+	}
+	if ref.SecretKey == "" {
+		ref.SecretKey = "token"
+	}
+	return ref, true
+}
+
+// loadBYOToken reads the token from the referenced Secret or AccessKey. It never
+// writes to either. Annotation references must point at an object labelled
+// vci.flux.loft.sh/byo-allowed=true, so annotating a VCI cannot exfiltrate
+// arbitrary credentials. Token Secrets generated by this controller are never
+// accepted, as they belong to other VCIs.
+func (r *VciReconciler) loadBYOToken(ctx context.Context, ref byoRef) (string, error) {
+	if ref.SecretName != "" {
+		var s corev1.Secret
+		if err := r.Get(ctx, types.NamespacedName{Namespace: ref.SecretNS, Name: ref.SecretName}, &s); err != nil {
+			return "", fmt.Errorf("get token secret %s/%s: %w", ref.SecretNS, ref.SecretName, err)
+		}
+		if s.Labels["app.kubernetes.io/managed-by"] == "vcluster-platform-flux-secret-controller" {
+			return "", fmt.Errorf("token secret %s/%s is managed by this controller and cannot be referenced",
+				ref.SecretNS, ref.SecretName)
+		}
+		if ref.FromAnnotation && s.Labels[lblBYOAllowed] != "true" {
+			return "", fmt.Errorf("token secret %s/%s is not labelled %s=true", ref.SecretNS, ref.SecretName, lblBYOAllowed)
+		}
+		tok := strings.TrimSpace(string(s.Data[ref.SecretKey]))
+		if tok == "" {
+			return "", fmt.Errorf("token secret %s/%s has no key %q", ref.SecretNS, ref.SecretName, ref.SecretKey)
+		}
+		return tok, nil
+	}
+
+	ak := unstructured.Unstructured{}
+	ak.SetGroupVersionKind(gvkAK)
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.AccessKey}, &ak); err != nil {
+		return "", fmt.Errorf("get AccessKey %s: %w", ref.AccessKey, err)
+	}
+	if ref.FromAnnotation && ak.GetLabels()[lblBYOAllowed] != "true" {
+		return "", fmt.Errorf("AccessKey %s is not labelled %s=true", ref.AccessKey, lblBYOAllowed)
+	}
+	tok, _, _ := unstructured.NestedString(ak.Object, "spec", "key")
+	if tok == "" {
+		return "", fmt.Errorf("AccessKey %s has no spec.key", ref.AccessKey)
+	}
+	return tok, nil
+}
+
+// revokeGeneratedCredentials removes the AccessKeys and token Secret this
+// controller generated for a VCI that has switched to bring-your-own mode.
+// Only our own, project-qualified objects are touched.
+func (r *VciReconciler) revokeGeneratedCredentials(ctx context.Context, vciNamespace, vciName string) error {
+	project := projectFromNamespace(vciNamespace)
+	_, akErr := r.deleteAccessKey(ctx, project, vciName)
+	_, prevErr := r.deletePreviousAccessKey(ctx, project, vciName)
+	_, tokErr := r.deleteTokenSecret(ctx, vciNamespace, vciName)
+	return utilerrors.NewAggregate([]error{akErr, prevErr, tokErr})
+}
+
+// byoCandidatePredicate passes Secrets and AccessKeys that a bring-your-own
+// reference may point at: the global flag targets and unmanaged objects labelled
+// vci.flux.loft.sh/byo-allowed=true.
+func (r *VciReconciler) byoCandidatePredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(o client.Object) bool {
+		if _, isSecret := o.(*corev1.Secret); isSecret {
+			if o.GetLabels()["app.kubernetes.io/managed-by"] == "vcluster-platform-flux-secret-controller" {
+				return false
+			}
+			if o.GetLabels()[lblBYOAllowed] == "true" {
+				return true
+			}
+			ns, name, ok := strings.Cut(r.Opts.ExistingTokenSecret, "/")
+			if !ok {
+				ns, name = r.Opts.ControllerNamespace, r.Opts.ExistingTokenSecret
+			}
+			return name != "" && o.GetNamespace() == ns && o.GetName() == name
+		}
+		if o.GetLabels()[lblBYOAllowed] == "true" {
+			return true
+		}
+		return r.Opts.ExistingAccessKey != "" && o.GetName() == r.Opts.ExistingAccessKey
+	})
+}
+
+// mapBYOObjectToVCIs requeues every selected VCI whose bring-your-own reference
+// points at o, so a rotated external token is republished right away.
+func (r *VciReconciler) mapBYOObjectToVCIs(ctx context.Context, o client.Object) []reconcile.Request {
+	vcis, err := r.selectedVCIs(ctx)
+	if err != nil {
+		crlog.FromContext(ctx).Error(err, "failed to list VCIs for credential event", "object", client.ObjectKeyFromObject(o))
+		return nil
+	}
+	var out []reconcile.Request
+	for i := range vcis {
+		if ref, ok := r.byoRefFor(&vcis[i]); ok && ref.matches(o) {
+			out = append(out, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&vcis[i])})
+		}
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// capiSecretType is the Secret type Cluster API uses for cluster kubeconfigs.
+const capiSecretType corev1.SecretType = "cluster.x-k8s.io/secret"
+
+// capiOutput is a Cluster API style "<cluster>-kubeconfig" Secret with the YAML
+// kubeconfig under "value", as read by CAPI-aware addon managers such as Sveltos.
+type capiOutput struct{ r *VciReconciler }
+
+func (capiOutput) Name() string { return OutputCAPI }
+
+func (c capiOutput) Namespaces(ctx context.Context) ([]string, error) {
+	return c.r.resolveNamespacePatterns(ctx, c.r.Opts.CAPINamespacePatterns, "default")
+}
+
+func (c capiOutput) MatchesNamespace(ns string) bool {
+	return matchesNamespacePatterns(c.r.Opts.CAPINamespacePatterns, "default", ns)
+}
+
+// clusterName renders --capi-cluster-name-template (default "<project>-<name>").
+func (c capiOutput) clusterName(in *publishInput) (string, error) {
+	tmpl := c.r.Opts.CAPIClusterNameTmpl
+	if tmpl == "" {
+		tmpl = "{{ .Project }}-{{ .Name }}"
+	}
+	cluster, err := renderKubeconfigName(tmpl, c.r.nameVarsFor(in.VCI))
+	if err != nil {
+		return "", fmt.Errorf("render CAPI cluster name: %w", err)
+	}
+	if errs := validation.IsValidLabelValue(cluster); len(errs) > 0 {
+		return "", fmt.Errorf("CAPI cluster name %q is not a valid label value: %s", cluster, strings.Join(errs, "; "))
+	}
+	return cluster, nil
+}
+
+func (c capiOutput) Render(in *publishInput) (secretOutput, error) {
+	cluster, err := c.clusterName(in)
+	if err != nil {
+		return secretOutput{}, err
+	}
+	y, err := yaml.JSONToYAML(in.KcfgJSON)
+	if err != nil {
+		return secretOutput{}, fmt.Errorf("encode kubeconfig as yaml: %w", err)
+	}
+	return secretOutput{
+		Name: cluster + "-kubeconfig",
+		Type: capiSecretType,
+		Labels: map[string]string{
+			"cluster.x-k8s.io/cluster-name": cluster,
+		},
+		Data: map[string][]byte{"value": y},
+	}, nil
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// vciFinalizer blocks VCI deletion until its AccessKey, token Secret and
+// Flux Secrets have been removed.
+const vciFinalizer = "vci.flux.loft.sh/cleanup"
+
+// revokeVCI deletes every credential issued for a VCI: all per-namespace Flux
+// Secrets, the AccessKey and the token Secret. Bootstrap objects and input
+// providers go first, and the credentials are kept (with a requeue) while
+// bootstrap objects are still finalizing, since HelmRelease uninstall and
+// Kustomization prune need the kubeconfig. Any error is returned so the caller
+// requeues instead of orphaning credentials.
+func (r *VciReconciler) revokeVCI(ctx context.Context, vciNamespace, vciName string) (ctrl.Result, error) {
+	project := projectFromNamespace(vciNamespace)
+	log := crlog.FromContext(ctx)
+
+	bsPending, bsErr := r.deleteBootstrapObjects(ctx, vciNamespace, vciName, nil)
+	_, ipErr := r.deleteInputProviders(ctx, vciNamespace, vciName, nil)
+	if err := utilerrors.NewAggregate([]error{bsErr, ipErr}); err != nil {
+		return ctrl.Result{}, err
+	}
+	if bsPending > 0 {
+		log.Info("waiting for bootstrap objects to be deleted before revoking credentials", "pending", bsPending)
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	secN, secErr := r.gcAllFluxSecretsForVCI(ctx, vciNamespace, vciName)
+	akOK, akErr := r.deleteAccessKey(ctx, project, vciName) // project-qualified AK name
+	prevOK, prevErr := r.deletePreviousAccessKey(ctx, project, vciName)
+	tokOK, tokErr := r.deleteTokenSecret(ctx, vciNamespace, vciName)
+	r.forgetVerification(types.NamespacedName{Namespace: vciNamespace, Name: vciName})
+
+	log.Info("cleanup after VCI delete",
+		"vci", types.NamespacedName{Namespace: vciNamespace, Name: vciName}.String(),
+		"project", project,
+		"secretsDeleted", secN,
+		"accessKeyDeleted", akOK,
+		"previousAccessKeyDeleted", prevOK,
+		"tokenSecretDeleted", tokOK,
+		"secErr", secErr,
+		"akErr", akErr,
+		"prevAkErr", prevErr,
+		"tokErr", tokErr,
+	)
+	return ctrl.Result{}, utilerrors.NewAggregate([]error{secErr, akErr, prevErr, tokErr})
+}
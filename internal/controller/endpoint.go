@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+
+	"github.com/loft-demos/vcluster-platform-flux-secret-controller/internal/util"
+)
+
+// Endpoint modes: "proxy" routes through the platform (--server-template),
+// "direct" targets the vCluster's own endpoint when one is exposed.
+const (
+	EndpointModeProxy  = "proxy"
+	EndpointModeDirect = "direct"
+)
+
+// annEndpointMode on a VCI overrides --endpoint-mode.
+const annEndpointMode = "vci.flux.loft.sh/endpoint-mode"
+
+// ValidEndpointMode reports whether m is proxy or direct.
+func ValidEndpointMode(m string) bool {
+	return m == EndpointModeProxy || m == EndpointModeDirect
+}
+
+// endpointModeFor returns the VCI's vci.flux.loft.sh/endpoint-mode annotation, else the global mode.
+func (r *VciReconciler) endpointModeFor(vci *unstructured.Unstructured) (string, error) {
+	if v, ok := vci.GetAnnotations()[annEndpointMode]; ok {
+		m := strings.ToLower(strings.TrimSpace(v))
+		if !ValidEndpointMode(m) {
+			return "", fmt.Errorf("invalid %s annotation %q (want proxy|direct)", annEndpointMode, v)
+		}
+		return m, nil
+	}
+	if r.Opts.EndpointMode == "" {
+		return EndpointModeProxy, nil
+	}
+	return r.Opts.EndpointMode, nil
+}
+
+// directEndpoint discovers the vCluster's own API endpoint and CA. It checks, in order,
+// the ingress host in the VCI's helm values (inline or rendered from a template
+// into status.virtualCluster), the server in the vCluster's kubeconfig Secret
+// (vc-<name>) and the LoadBalancer address of the vCluster Service (<name>) on
+// the host cluster. ok is false if none is exposed outside the vCluster pod. caPEM is nil when the vCluster CA is unknown, so the system roots
+// are used instead of the platform CA.
+func (r *VciReconciler) directEndpoint(ctx context.Context, vci *unstructured.Unstructured) (server string, caPEM []byte, ok bool, err error) {
+	vcName := util.GetString(vci, "spec", "clusterRef", "virtualCluster")
+	if vcName == "" {
+		vcName = vci.GetName()
+	}
+	hostNS := util.GetString(vci, "spec", "clusterRef", "namespace")
+	// the vc-<name> Secret is only readable when the vCluster runs on our own cluster
+	hostCluster := util.GetString(vci, "spec", "clusterRef", "cluster")
+	local := hostCluster == "" || r.Opts.LocalClusterName == "" || hostCluster == r.Opts.LocalClusterName
+
+	// vCluster kubeconfig Secret: gives the CA, and the server if exportKubeConfig.server is set
+	var secCA []byte
+	var secServer string
+	if hostNS != "" && local {
+		var s corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Namespace: hostNS, Name: "vc-" + vcName}, &s)
+		switch {
+		case err == nil:
+			if cfg, lerr := clientcmd.Load(s.Data["config"]); lerr == nil {
+				if kctx, found := cfg.Contexts[cfg.CurrentContext]; found {
+					if c, found := cfg.Clusters[kctx.Cluster]; found {
+						secServer, secCA = c.Server, c.CertificateAuthorityData
+					}
+				}
+			}
+		case !apierrors.IsNotFound(err):
+			return "", nil, false, fmt.Errorf("get vCluster kubeconfig secret %s/vc-%s: %w", hostNS, vcName, err)
+		}
+	}
+
+	for _, path := range [][]string{
+		{"spec", "template", "helmRelease", "values"},
+		{"status", "virtualCluster", "helmRelease", "values"}, // resolved from spec.templateRef
+	} {
+		if host := ingressHostFromValues(util.GetString(vci, path...)); host != "" {
+			return "https://" + host, secCA, true, nil
+		}
+	}
+	if secServer != "" && !isLoopbackURL(secServer) {
+		return secServer, secCA, true, nil
+	}
+	if hostNS != "" && local {
+		var svc corev1.Service
+		err := r.Get(ctx, types.NamespacedName{Namespace: hostNS, Name: vcName}, &svc)
+		switch {
+		case err == nil:
+			if server := loadBalancerURL(&svc); server != "" {
+				return server, secCA, true, nil
+			}
+		case !apierrors.IsNotFound(err):
+			return "", nil, false, fmt.Errorf("get vCluster service %s/%s: %w", hostNS, vcName, err)
+		}
+	}
+	return "", nil, false, nil
+}
+
+// loadBalancerURL returns the https URL of a LoadBalancer Service's first ingress
+// address, on its "https" port (or its only port). Empty until an address is assigned.
+func loadBalancerURL(svc *corev1.Service) string {
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer || len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return ""
+	}
+	ing := svc.Status.LoadBalancer.Ingress[0]
+	host := ing.Hostname
+	if host == "" {
+		host = ing.IP
+	}
+	if host == "" {
+		return ""
+	}
+	port := int32(443)
+	for _, p := range svc.Spec.Ports {
+		if p.Name == "https" || len(svc.Spec.Ports) == 1 {
+			port = p.Port
+			break
+		}
+	}
+	switch {
+	case port != 443:
+		host = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	case strings.Contains(host, ":"):
+		host = "[" + host + "]" // IPv6
+	}
+	return "https://" + host
+}
+
+// ingressHostFromValues returns controlPlane.ingress.host when the ingress is enabled.
+func ingressHostFromValues(values string) string {
+	if strings.TrimSpace(values) == "" {
+		return ""
+	}
+	var v struct {
+		ControlPlane struct {
+			Ingress struct {
+				Enabled bool   `json:"enabled"`
+				Host    string `json:"host"`
+			} `json:"ingress"`
+		} `json:"controlPlane"`
+	}
+	if err := yaml.Unmarshal([]byte(values), &v); err != nil {
+		return ""
+	}
+	if !v.ControlPlane.Ingress.Enabled {
+		return ""
+	}
+	return v.ControlPlane.Ingress.Host
+}
+
+// isLoopbackURL reports whether the server only works from inside the vCluster pod.
+func isLoopbackURL(server string) bool {
+	u, err := url.Parse(server)
+	if err != nil {
+		return true
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// gvkInputProvider is the Flux Operator ResourceSetInputProvider, handled as
+// unstructured so the controller has no Flux Operator module dependency.
+var gvkInputProvider = schema.GroupVersionKind{Group: "fluxcd.controlplane.io", Version: "v1", Kind: "ResourceSetInputProvider"}
+
+// lblInputProvider marks the input providers we publish, for ResourceSet
+// inputsFrom selectors.
+const lblInputProvider = "vci.flux.loft.sh/input-provider"
+
+// vciInputs are the ResourceSet inputs exported for one VCI.
+type vciInputs struct {
+	Name            string            `json:"name"`
+	Project         string            `json:"project"`
+	Namespace       string            `json:"namespace"`
+	Server          string            `json:"server"`
+	SecretName      string            `json:"secretName"`
+	SecretNamespace string            `json:"secretNamespace"`
+	SecretKey       string            `json:"secretKey"`
+	Labels          map[string]string `json:"labels"`
+}
+
+// reconcileInputProviders publishes a Static ResourceSetInputProvider next to the
+// VCI's Flux kubeconfig Secret in every Flux namespace, then prunes providers it
+// no longer publishes. secretNS maps each Flux namespace to the Secret. Providers
+// of the same name that we don't own are skipped with an InputProviderConflict event.
+func (r *VciReconciler) reconcileInputProviders(ctx context.Context, in *publishInput, secretNS map[string]fluxSecretRef) (int, error) {
+	vci := in.VCI
+	keep := map[types.NamespacedName]struct{}{}
+
+	namespaces := make([]string, 0, len(secretNS))
+	for ns := range secretNS {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		ref := secretNS[ns]
+		if ref.Key == "" {
+			return 0, fmt.Errorf("secret template has several data keys and no kubeconfigKey; input providers cannot reference %s/%s", ns, ref.Name)
+		}
+		inputs := vciInputs{
+			Name:            vci.GetName(),
+			Project:         in.Project,
+			Namespace:       vci.GetNamespace(),
+			Server:          in.Server,
+			SecretName:      ref.Name,
+			SecretNamespace: ns,
+			SecretKey:       ref.Key,
+			Labels:          r.copyAllVCILabels(vci.GetLabels()),
+		}
+		nn := types.NamespacedName{Namespace: ns, Name: ref.Name}
+		err := r.upsertInputProvider(ctx, in, nn, inputs)
+		if errors.Is(err, errNotOwned) {
+			r.Recorder.Eventf(vci, corev1.EventTypeWarning, "InputProviderConflict",
+				"ResourceSetInputProvider %s already exists and is not managed for this VCI; leaving it alone", nn)
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("upsert input provider %s: %w", nn, err)
+		}
+		keep[nn] = struct{}{}
+	}
+	return r.deleteInputProviders(ctx, vci.GetNamespace(), vci.GetName(), keep)
+}
+
+// upsertInputProvider creates or updates one Static input provider. Its labels
+// mirror the Secret's so ResourceSets can select providers by VCI label. An
+// existing provider we don't own for this VCI returns errNotOwned.
+func (r *VciReconciler) upsertInputProvider(ctx context.Context, in *publishInput, nn types.NamespacedName, inputs vciInputs) error {
+	vci := in.VCI
+	raw, err := json.Marshal(inputs)
+	if err != nil {
+		return err
+	}
+	var defaults map[string]any
+	if err := json.Unmarshal(raw, &defaults); err != nil {
+		return err
+	}
+
+	lbl := r.copyAllVCILabels(vci.GetLabels())
+	lbl["app.kubernetes.io/managed-by"] = "vcluster-platform-flux-secret-controller"
+	lbl["vci.flux.loft.sh/name"] = vci.GetName()
+	lbl["vci.flux.loft.sh/namespace"] = vci.GetNamespace()
+	lbl["vci.flux.loft.sh/project"] = in.Project
+	lbl[lblInputProvider] = "true"
+
+	spec := map[string]any{
+		"type":          "Static",
+		"defaultValues": defaults,
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(gvkInputProvider)
+	err = r.Get(ctx, nn, existing)
+	if apierrors.IsNotFound(err) {
+		want := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+		want.SetGroupVersionKind(gvkInputProvider)
+		want.SetNamespace(nn.Namespace)
+		want.SetName(nn.Name)
+		want.SetLabels(lbl)
+		return r.Create(ctx, want)
+	}
+	if err != nil {
+		return err
+	}
+	if !ownedBy(existing, vci.GetNamespace(), vci.GetName()) {
+		return errNotOwned
+	}
+
+	before := existing.DeepCopy()
+	existing.Object["spec"] = spec
+	cur := existing.GetLabels()
+	if cur == nil {
+		cur = map[string]string{}
+	}
+	for k, v := range lbl {
+		cur[k] = v
+	}
+	existing.SetLabels(cur)
+	// skip no-op updates so they don't feed back into our own watch
+	if equality.Semantic.DeepEqual(before.Object, existing.Object) {
+		return nil
+	}
+	return r.Update(ctx, existing)
+}
+
+// deleteInputProviders deletes the VCI's input providers that are not in keep
+// (nil deletes all). Returns number of objects deleted.
+func (r *VciReconciler) deleteInputProviders(ctx context.Context, vciNamespace, vciName string, keep map[types.NamespacedName]struct{}) (int, error) {
+	if !r.Opts.InputProviders {
+		return 0, nil // Flux Operator CRDs may not be installed; nothing was ever published
+	}
+	var list unstructured.UnstructuredList
+	list.SetGroupVersionKind(gvkInputProvider.GroupVersion().WithKind(gvkInputProvider.Kind + "List"))
+	sel := labels.SelectorFromSet(map[string]string{
+		"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
+		"vci.flux.loft.sh/name":        vciName,
+		"vci.flux.loft.sh/namespace":   vciNamespace,
+	})
+	if err := r.List(ctx, &list, &client.ListOptions{LabelSelector: sel}); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range list.Items {
+		if _, ok := keep[client.ObjectKeyFromObject(&list.Items[i])]; ok {
+			continue
+		}
+		if err := r.Delete(ctx, &list.Items[i]); client.IgnoreNotFound(err) != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -3,48 +3,67 @@ package controller
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/base64"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"text/template"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
 )
 
-type serverVars struct {
-	Domain    string
+// kubeconfigNames are the cluster, context and user entry names in a kubeconfig.
+type kubeconfigNames struct {
+	Cluster, Context, User string
+}
+
+// nameVars is the template context for kubeconfig entry names.
+type nameVars struct {
+	Name      string
 	Project   string
 	Namespace string
-	Name      string
+	Domain    string
+	Labels    map[string]string
 }
 
-type Kubeconfig struct {
-	APIVersion     string `json:"apiVersion"`
-	Kind           string `json:"kind"`
-	Clusters       []struct {
-		Name    string `json:"name"`
-		Cluster struct {
-			Server                   string `json:"server"`
-			CertificateAuthorityData string `json:"certificate-authority-data,omitempty"`
-			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify,omitempty"`
-		} `json:"cluster"`
-	} `json:"clusters"`
-	Contexts []struct {
-		Name    string `json:"name"`
-		Context struct {
-			Cluster string `json:"cluster"`
-			User    string `json:"user"`
-		} `json:"context"`
-	} `json:"contexts"`
-	CurrentContext string `json:"current-context"`
-	Users          []struct {
-		Name string `json:"name"`
-		User struct {
-			Token string `json:"token"`
-		} `json:"user"`
-	} `json:"users"`
+// KubeconfigOptions carries the optional kubeconfig fields beyond server, CA and token.
+type KubeconfigOptions struct {
+	ProxyURL              string
+	TLSServerName         string
+	InsecureSkipTLSVerify bool
+	Namespace             string // default namespace on the context
+	ClientCertificateData []byte
+	ClientKeyData         []byte
+	Exec                  *clientcmdapi.ExecConfig
+	Extensions            map[string]runtime.Object // added to cluster, context and user
 }
 
-func renderServerURL(tmplStr string, vars serverVars) (string, error) {
-	tmpl, err := template.New("server").Parse(tmplStr)
+// ParseKubeconfigExtensions parses a JSON object of extension name -> arbitrary JSON value.
+func ParseKubeconfigExtensions(s string) (map[string]runtime.Object, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, fmt.Errorf("parse kubeconfig extensions: %w", err)
+	}
+	out := make(map[string]runtime.Object, len(raw))
+	for k, v := range raw {
+		out[k] = &runtime.Unknown{Raw: v, ContentType: runtime.ContentTypeJSON}
+	}
+	return out, nil
+}
+
+// renderKubeconfigName renders a cluster/context/user name template; an empty template yields "loft".
+func renderKubeconfigName(tmplStr string, vars nameVars) (string, error) {
+	if tmplStr == "" {
+		return "loft", nil
+	}
+	tmpl, err := template.New("kcfgName").Option("missingkey=zero").Parse(tmplStr)
 	if err != nil {
 		return "", err
 	}
@@ -52,75 +71,71 @@ func renderServerURL(tmplStr string, vars serverVars) (string, error) {
 	if err := tmpl.Execute(&buf, vars); err != nil {
 		return "", err
 	}
-	return buf.String(), nil
+	out := strings.TrimSpace(buf.String())
+	if out == "" {
+		return "", fmt.Errorf("template %q rendered an empty name", tmplStr)
+	}
+	return out, nil
 }
 
-func buildKubeconfigBytes(server, _ignoredName, token string, caPEM []byte) ([]byte, string, error) {
-	const name = "loft" // fixed cluster/context/user name
-
-	cfg := Kubeconfig{
-		APIVersion: "v1",
-		Kind:       "Config",
+// ValidateKubeconfigOptions builds a sample kubeconfig with opts so broken flag
+// combinations (e.g. a client certificate without a key, or an exec plugin
+// without an apiVersion) are reported once at startup instead of on every reconcile.
+func ValidateKubeconfigOptions(opts KubeconfigOptions) error {
+	if len(opts.ClientCertificateData) > 0 && len(opts.ClientKeyData) > 0 {
+		if _, err := tls.X509KeyPair(opts.ClientCertificateData, opts.ClientKeyData); err != nil {
+			return fmt.Errorf("invalid client certificate/key pair: %w", err)
+		}
 	}
+	names := kubeconfigNames{Cluster: "loft", Context: "loft", User: "loft"}
+	_, _, err := buildKubeconfigBytes("https://example.invalid", names, "token", nil, opts)
+	return err
+}
 
-	// Cluster
-	cfg.Clusters = []struct {
-		Name    string `json:"name"`
-		Cluster struct {
-			Server                   string `json:"server"`
-			CertificateAuthorityData string `json:"certificate-authority-data,omitempty"`
-			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify,omitempty"`
-		} `json:"cluster"`
-	}{
-		{
-			Name: name,
-			Cluster: func() (out struct {
-				Server                   string `json:"server"`
-				CertificateAuthorityData string `json:"certificate-authority-data,omitempty"`
-				InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify,omitempty"`
-			}) {
-				out.Server = server
-				if len(caPEM) > 0 {
-					out.CertificateAuthorityData = base64.StdEncoding.EncodeToString(caPEM)
-				}
-				// leave InsecureSkipTLSVerify unset (false) unless you *want* it true
-				return out
-			}(),
-		},
+func buildKubeconfigBytes(server string, names kubeconfigNames, token string, caPEM []byte, opts KubeconfigOptions) ([]byte, string, error) {
+	cluster := clientcmdapi.NewCluster()
+	cluster.Server = server
+	cluster.InsecureSkipTLSVerify = opts.InsecureSkipTLSVerify
+	if !opts.InsecureSkipTLSVerify {
+		// client-go refuses a root CA together with insecure-skip-tls-verify
+		cluster.CertificateAuthorityData = caPEM
 	}
+	cluster.TLSServerName = opts.TLSServerName
+	cluster.ProxyURL = opts.ProxyURL
+
+	kctx := clientcmdapi.NewContext()
+	kctx.Cluster = names.Cluster
+	kctx.AuthInfo = names.User
+	kctx.Namespace = opts.Namespace
 
-	// Context
-	cfg.Contexts = []struct {
-		Name    string `json:"name"`
-		Context struct {
-			Cluster string `json:"cluster"`
-			User    string `json:"user"`
-		} `json:"context"`
-	}{
-		{
-			Name: name,
-			Context: struct {
-				Cluster string `json:"cluster"`
-				User    string `json:"user"`
-			}{Cluster: name, User: name},
-		},
+	user := clientcmdapi.NewAuthInfo()
+	user.Token = token
+	user.ClientCertificateData = opts.ClientCertificateData
+	user.ClientKeyData = opts.ClientKeyData
+	user.Exec = opts.Exec
+
+	for k, v := range opts.Extensions {
+		cluster.Extensions[k] = v
+		kctx.Extensions[k] = v
+		user.Extensions[k] = v
 	}
-	cfg.CurrentContext = name
-
-	// User
-	cfg.Users = []struct {
-		Name string `json:"name"`
-		User struct {
-			Token string `json:"token"`
-		} `json:"user"`
-	}{
-		{
-			Name: name,
-			User: struct{ Token string `json:"token"` }{Token: token},
-		},
+
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters[names.Cluster] = cluster
+	cfg.Contexts[names.Context] = kctx
+	cfg.AuthInfos[names.User] = user
+	cfg.CurrentContext = names.Context
+
+	if err := clientcmd.Validate(*cfg); err != nil {
+		return nil, "", fmt.Errorf("invalid kubeconfig: %w", err)
 	}
 
-	j, err := json.Marshal(cfg)
+	y, err := clientcmd.Write(*cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	// canonical form is JSON; the checksum covers it regardless of output encodings
+	j, err := yaml.YAMLToJSON(y)
 	if err != nil {
 		return nil, "", err
 	}
@@ -128,3 +143,57 @@ func buildKubeconfigBytes(server, _ignoredName, token string, caPEM []byte) ([]b
 	return j, fmt.Sprintf("%x", sum[:]), nil
 }
 
+// OutputKey is one Secret.data entry and the encoding of the kubeconfig stored under it.
+type OutputKey struct {
+	Key      string
+	Encoding string // "json" or "yaml"
+}
+
+// ParseOutputKeys parses "value=yaml,value.json=json". A key without "=" is JSON.
+// Keys must be valid, unique Secret data keys.
+func ParseOutputKeys(s string) ([]OutputKey, error) {
+	var out []OutputKey
+	seen := map[string]bool{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, enc, ok := strings.Cut(kv, "=")
+		if !ok {
+			enc = "json"
+		}
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		if enc != "json" && enc != "yaml" {
+			return nil, fmt.Errorf("invalid encoding %q for key %q (want json|yaml)", enc, key)
+		}
+		key = strings.TrimSpace(key)
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid Secret key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate Secret key %q", key)
+		}
+		seen[key] = true
+		out = append(out, OutputKey{Key: key, Encoding: enc})
+	}
+	return out, nil
+}
+
+// renderKubeconfigData encodes the canonical (JSON) kubeconfig once per output key.
+func renderKubeconfigData(kcfgJSON []byte, keys []OutputKey) (map[string][]byte, error) {
+	data := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		switch k.Encoding {
+		case "yaml":
+			y, err := yaml.JSONToYAML(kcfgJSON)
+			if err != nil {
+				return nil, fmt.Errorf("encode %s as yaml: %w", k.Key, err)
+			}
+			data[k.Key] = y
+		default:
+			data[k.Key] = kcfgJSON
+		}
+	}
+	return data, nil
+}
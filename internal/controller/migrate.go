@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// SetupTokenSecretMigration registers a one-shot, leader-elected Runnable that
+// renames legacy "<prefix><name>-ak" token Secrets to the project-qualified name.
+func (r *VciReconciler) SetupTokenSecretMigration(mgr ctrl.Manager) error {
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return fmt.Errorf("token secret migration: caches did not sync")
+		}
+		r.migrateLegacyTokenSecrets(ctx)
+		return nil
+	}))
+}
+
+func (r *VciReconciler) migrateLegacyTokenSecrets(ctx context.Context) {
+	log := r.Log.WithName("token-migration")
+
+	var list corev1.SecretList
+	if err := r.List(ctx, &list,
+		client.InNamespace(r.Opts.ControllerNamespace),
+		client.MatchingLabels{"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller"},
+	); err != nil {
+		log.Error(err, "failed to list token Secrets")
+		return
+	}
+
+	migrated, failed := 0, 0
+	for i := range list.Items {
+		old := &list.Items[i]
+		ref := old.Annotations["vci.flux.loft.sh/vci"]
+		vciNS, vciName, ok := strings.Cut(ref, "/")
+		if !ok || vciNS == "" || vciName == "" {
+			continue
+		}
+		if old.Name != legacyTokenSecretName(r.Opts.SecretPrefix, vciName) {
+			continue
+		}
+		newName := tokenSecretName(r.Opts.SecretPrefix, projectFromNamespace(vciNS), vciNS, vciName)
+		if newName == old.Name {
+			continue
+		}
+
+		var existing corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Namespace: old.Namespace, Name: newName}, &existing)
+		switch {
+		case apierrors.IsNotFound(err):
+			moved := corev1.Secret{
+				ObjectMeta: meta.ObjectMeta{
+					Name:        newName,
+					Namespace:   old.Namespace,
+					Labels:      old.Labels,
+					Annotations: old.Annotations,
+				},
+				Type: old.Type,
+				Data: old.Data,
+			}
+			if err := r.Create(ctx, &moved); err != nil && !apierrors.IsAlreadyExists(err) {
+				log.Error(err, "failed to create migrated token Secret", "from", old.Name, "to", newName)
+				failed++
+				continue
+			}
+		case err != nil:
+			log.Error(err, "failed to get token Secret", "name", newName)
+			failed++
+			continue
+		}
+		// new Secret exists (migrated now or already written by a reconcile): drop the legacy one
+		if err := r.Delete(ctx, old); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "failed to delete legacy token Secret", "name", old.Name)
+			failed++
+			continue
+		}
+		migrated++
+	}
+
+	log.Info("legacy token Secret migration finished", "migrated", migrated, "failed", failed)
+}
@@ -10,9 +10,14 @@ import (
 )
 
 func (r *VciReconciler) resolveFluxNamespaces(ctx context.Context) ([]string, error) {
-	pats := r.Opts.FluxNamespacePatterns
+	return r.resolveNamespacePatterns(ctx, r.Opts.FluxNamespacePatterns, "flux-system")
+}
+
+// resolveNamespacePatterns expands exact names and globs to existing namespaces;
+// exact names are kept even if the namespace does not exist yet.
+func (r *VciReconciler) resolveNamespacePatterns(ctx context.Context, pats []string, def string) ([]string, error) {
 	if len(pats) == 0 {
-		pats = []string{"flux-system"}
+		pats = []string{def}
 	}
 	// Trim + de-dup & track if any glob
 	seen := map[string]struct{}{}
@@ -61,3 +66,29 @@ func (r *VciReconciler) resolveFluxNamespaces(ctx context.Context) ([]string, er
 	}
 	return names, nil
 }
+
+// matchesFluxNamespace reports whether a namespace name is selected by
+// --flux-namespaces (exact names or globs).
+func (r *VciReconciler) matchesFluxNamespace(name string) bool {
+	return matchesNamespacePatterns(r.Opts.FluxNamespacePatterns, "flux-system", name)
+}
+
+// matchesNamespacePatterns reports whether name matches one of pats (def if none).
+func matchesNamespacePatterns(pats []string, def, name string) bool {
+	if len(pats) == 0 {
+		pats = []string{def}
+	}
+	for _, p := range pats {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if p == name {
+			return true
+		}
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
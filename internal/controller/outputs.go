@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Output formats selectable with --outputs.
+const (
+	OutputFlux   = "flux"
+	OutputArgoCD = "argocd"
+	OutputCAPI   = "capi"
+)
+
+// lblOutput on published Secrets names the output format that wrote them.
+const lblOutput = "vci.flux.loft.sh/output"
+
+// ParseOutputs parses --outputs ("flux,argocd"); empty means flux only.
+func ParseOutputs(s string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, o := range strings.Split(s, ",") {
+		o = strings.ToLower(strings.TrimSpace(o))
+		if o == "" || seen[o] {
+			continue
+		}
+		switch o {
+		case OutputFlux, OutputArgoCD, OutputCAPI:
+		default:
+			return nil, fmt.Errorf("unknown output %q (want flux|argocd|capi)", o)
+		}
+		seen[o] = true
+		out = append(out, o)
+	}
+	if len(out) == 0 {
+		out = []string{OutputFlux}
+	}
+	return out, nil
+}
+
+// publishInput is everything an output format can render a Secret from.
+type publishInput struct {
+	VCI        *unstructured.Unstructured
+	Project    string
+	Server     string
+	Token      string
+	CAPEM      []byte
+	Names      kubeconfigNames
+	Kubeconfig KubeconfigOptions
+	KcfgJSON   []byte // canonical JSON kubeconfig
+	Sum        string // sha256 of KcfgJSON
+}
+
+// secretOutput is the format-specific part of a published Secret. Common
+// ownership, phase and VCI labels are added by upsertOutputSecret.
+type secretOutput struct {
+	Name          string
+	Type          corev1.SecretType
+	Labels        map[string]string
+	Annotations   map[string]string
+	Data          map[string][]byte
+	KubeconfigKey string // data key holding the kubeconfig, if any (Flux output only)
+}
+
+// fluxSecretRef is a published Flux kubeconfig Secret, as referenced by bootstrap
+// objects and input providers.
+type fluxSecretRef struct {
+	Name string
+	Key  string // "" if the Secret template does not say which key holds the kubeconfig
+}
+
+// outputFormat renders the Secret one downstream tool expects for a VCI.
+type outputFormat interface {
+	// Name is the --outputs value and the vci.flux.loft.sh/output label.
+	Name() string
+	// Namespaces returns the namespaces the Secret is published into.
+	Namespaces(ctx context.Context) ([]string, error)
+	// MatchesNamespace reports whether a namespace is one of Namespaces, for watches.
+	MatchesNamespace(ns string) bool
+	// Render builds the Secret name, type, labels, annotations and data.
+	Render(in *publishInput) (secretOutput, error)
+}
+
+// outputFormats returns the enabled formats in --outputs order.
+func (r *VciReconciler) outputFormats() []outputFormat {
+	names := r.Opts.Outputs
+	if len(names) == 0 {
+		names = []string{OutputFlux}
+	}
+	out := make([]outputFormat, 0, len(names))
+	for _, n := range names {
+		switch n {
+		case OutputFlux:
+			out = append(out, fluxOutput{r})
+		case OutputArgoCD:
+			out = append(out, argoCDOutput{r})
+		case OutputCAPI:
+			out = append(out, capiOutput{r})
+		}
+	}
+	return out
+}
+
+// matchesOutputNamespace reports whether any enabled format publishes into ns.
+func (r *VciReconciler) matchesOutputNamespace(ns string) bool {
+	for _, f := range r.outputFormats() {
+		if f.MatchesNamespace(ns) {
+			return true
+		}
+	}
+	return false
+}
+
+// fluxOutput is the Flux kubeconfig Secret: fluxcd.io/kubeconfig=true and the
+// kubeconfig under --secret-key / --secret-keys.
+type fluxOutput struct{ r *VciReconciler }
+
+func (fluxOutput) Name() string { return OutputFlux }
+
+func (f fluxOutput) Namespaces(ctx context.Context) ([]string, error) {
+	return f.r.resolveFluxNamespaces(ctx)
+}
+
+func (f fluxOutput) MatchesNamespace(ns string) bool { return f.r.matchesFluxNamespace(ns) }
+
+// Render builds the default Flux Secret and, with --secret-template-file, applies
+// the user's name, type, labels, annotations and data keys on top of it.
+func (f fluxOutput) Render(in *publishInput) (secretOutput, error) {
+	data, err := renderKubeconfigData(in.KcfgJSON, f.r.outputKeys())
+	if err != nil {
+		return secretOutput{}, err
+	}
+	out := secretOutput{
+		Name: f.r.secretNameFor(in.Project, in.VCI.GetName()),
+		Type: corev1.SecretTypeOpaque,
+		Labels: map[string]string{
+			"fluxcd.io/kubeconfig":  "true",
+			"fluxcd.io/secret-type": "cluster",
+		},
+		Data:          data,
+		KubeconfigKey: f.r.outputKeys()[0].Key,
+	}
+	st := f.r.Opts.SecretTemplate
+	if st == nil {
+		return out, nil
+	}
+	vars, err := f.r.secretTemplateVarsFor(in)
+	if err != nil {
+		return secretOutput{}, err
+	}
+	return st.render(vars, out)
+}
+
+// upsertOutputSecret creates or updates one published Secret. Ownership, phase,
+// verification and propagated VCI labels are common to every format; the
+// format's own labels win over propagated ones. A changed Secret type recreates
+// the Secret, since the type is immutable.
+func (r *VciReconciler) upsertOutputSecret(
+	ctx context.Context,
+	in *publishInput,
+	format, ns string,
+	out secretOutput,
+	verified *bool, // nil when verification is off
+) error {
+	vci := in.VCI
+
+	// format labels, then the base labels we always set (ownership can't be overridden)
+	lbl := map[string]string{}
+	for k, v := range out.Labels {
+		lbl[k] = v
+	}
+	for k, v := range map[string]string{
+		"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
+		"vci.flux.loft.sh/name":        vci.GetName(),
+		"vci.flux.loft.sh/namespace":   vci.GetNamespace(),
+		"vci.flux.loft.sh/project":     in.Project,
+		"vci.flux.loft.sh/phase":       "Ready",
+		lblOutput:                      format,
+	} {
+		lbl[k] = v
+	}
+	if verified != nil {
+		lbl[lblVerified] = strconv.FormatBool(*verified)
+	}
+	// merge ALL user labels from VCI (minus reserved/system)
+	for k, v := range r.copyAllVCILabels(vci.GetLabels()) {
+		if _, reserved := lbl[k]; !reserved {
+			lbl[k] = v
+		}
+	}
+
+	ann := map[string]string{}
+	for k, v := range out.Annotations {
+		ann[k] = v
+	}
+	ann["vci.flux.loft.sh/kcfg-sha256"] = in.Sum
+
+	var existing corev1.Secret
+	name := out.Name
+	err := r.Get(ctx, types.NamespacedName{Namespace: ns, Name: name}, &existing)
+	if err == nil && existing.Type != out.Type {
+		if err := r.Delete(ctx, &existing); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		err = apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			sec := corev1.Secret{
+				ObjectMeta: meta.ObjectMeta{
+					Name:        name,
+					Namespace:   ns,
+					Labels:      lbl,
+					Annotations: ann,
+				},
+				Type: out.Type,
+				Data: out.Data,
+			}
+			return r.Create(ctx, &sec)
+		}
+		return err
+	}
+
+	// ---- UPDATE path: detect drift in data, annotations, OR labels ----
+	dataChanged := !equality.Semantic.DeepEqual(existing.Data, out.Data)
+	annChanged := false
+	for k, v := range ann {
+		if cur, ok := existing.Annotations[k]; !ok || cur != v {
+			annChanged = true
+		}
+	}
+
+	// build the would-be label map and compare
+	desiredLabels := map[string]string{}
+	for k, v := range existing.Labels { // start from existing to preserve unrelated keys you might want to keep
+		desiredLabels[k] = v
+	}
+	for k, v := range lbl { // ensure our desired keys/values are present
+		desiredLabels[k] = v
+	}
+	if verified == nil {
+		delete(desiredLabels, lblVerified) // verification switched off: drop the stale result
+	}
+	labelsChanged := !equalStringMap(existing.Labels, desiredLabels)
+
+	if dataChanged || annChanged || labelsChanged {
+		existing.Data = out.Data
+
+		if existing.Annotations == nil {
+			existing.Annotations = map[string]string{}
+		}
+		for k, v := range ann {
+			existing.Annotations[k] = v
+		}
+
+		existing.Labels = desiredLabels
+
+		return r.Update(ctx, &existing)
+	}
+	return nil
+}
+
+// pruneOutputSecretsExcept deletes this VCI's published Secrets that are not in
+// keep, e.g. after a namespace stopped matching or an output was disabled.
+// Returns number of secrets deleted.
+func (r *VciReconciler) pruneOutputSecretsExcept(ctx context.Context, vciNamespace, vciName string, keep map[types.NamespacedName]struct{}) (int, error) {
+	var list corev1.SecretList
+	sel := labels.SelectorFromSet(map[string]string{
+		"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
+		"vci.flux.loft.sh/name":        vciName,
+		"vci.flux.loft.sh/namespace":   vciNamespace,
+	})
+	if err := r.List(ctx, &list, &client.ListOptions{LabelSelector: sel}); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range list.Items {
+		if _, ok := keep[client.ObjectKeyFromObject(&list.Items[i])]; ok {
+			continue
+		}
+		if err := r.Delete(ctx, &list.Items[i]); client.IgnoreNotFound(err) != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// nameVarsFor is the name template context (kubeconfig entries, Argo CD and CAPI
+// cluster names) for a VCI.
+func (r *VciReconciler) nameVarsFor(vci *unstructured.Unstructured) nameVars {
+	return nameVars{
+		Name:      vci.GetName(),
+		Project:   projectFromNamespace(vci.GetNamespace()),
+		Namespace: vci.GetNamespace(),
+		Domain:    r.Opts.LoftDomain,
+		Labels:    vci.GetLabels(),
+	}
+}
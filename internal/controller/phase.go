@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// PhaseAction is what happens to a VCI's Flux Secrets while it is not Ready.
+type PhaseAction string
+
+const (
+	// PhaseKeep leaves the Secrets untouched (default).
+	PhaseKeep PhaseAction = "keep"
+	// PhaseAnnotate stamps vci.flux.loft.sh/phase=<phase> on the Secrets so
+	// Flux objects can be suspended by label.
+	PhaseAnnotate PhaseAction = "annotate"
+	// PhaseWithdraw deletes the Secrets; the AccessKey is kept so they are
+	// republished once the VCI is Ready again.
+	PhaseWithdraw PhaseAction = "withdraw"
+)
+
+// ParsePhasePolicy parses "Sleeping=annotate,Failed=withdraw" into a phase->action map.
+func ParsePhasePolicy(s string) (map[string]PhaseAction, error) {
+	out := map[string]PhaseAction{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		phase, action, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid phase policy entry %q (want Phase=action)", kv)
+		}
+		a := PhaseAction(strings.ToLower(strings.TrimSpace(action)))
+		switch a {
+		case PhaseKeep, PhaseAnnotate, PhaseWithdraw:
+		default:
+			return nil, fmt.Errorf("invalid phase action %q for phase %q (want keep|annotate|withdraw)", action, phase)
+		}
+		out[strings.TrimSpace(phase)] = a
+	}
+	return out, nil
+}
+
+// reconcileNotReady applies the configured phase policy to a VCI that is not Ready,
+// and requeues transitional phases instead of waiting for the next watch event.
+func (r *VciReconciler) reconcileNotReady(ctx context.Context, vci *unstructured.Unstructured, phase string) (ctrl.Result, error) {
+	log := crlog.FromContext(ctx).WithValues("phase", phase)
+
+	action := r.Opts.PhasePolicy[phase]
+	if action == "" {
+		action = PhaseKeep
+	}
+	switch action {
+	case PhaseAnnotate:
+		n, err := r.labelFluxSecretsPhase(ctx, vci.GetNamespace(), vci.GetName(), phase)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("label secrets with phase: %w", err)
+		}
+		log.Info("VCI not Ready, labelled Secrets with phase", "secretsUpdated", n)
+	case PhaseWithdraw:
+		n, err := r.gcAllFluxSecretsForVCI(ctx, vci.GetNamespace(), vci.GetName())
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("withdraw secrets: %w", err)
+		}
+		log.Info("VCI not Ready, withdrew Secrets", "secretsDeleted", n)
+	default:
+		log.Info("VCI not Ready yet")
+	}
+
+	if r.Opts.PhaseRequeueAfter > 0 && r.isTransitionalPhase(phase) {
+		return ctrl.Result{RequeueAfter: r.Opts.PhaseRequeueAfter}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *VciReconciler) isTransitionalPhase(phase string) bool {
+	for _, p := range r.Opts.TransitionalPhases {
+		if strings.TrimSpace(p) == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// labelFluxSecretsPhase sets vci.flux.loft.sh/phase on every Secret of the VCI.
+// Returns number of secrets updated.
+func (r *VciReconciler) labelFluxSecretsPhase(ctx context.Context, vciNamespace, vciName, phase string) (int, error) {
+	var list corev1.SecretList
+	sel := labels.SelectorFromSet(map[string]string{
+		"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
+		"vci.flux.loft.sh/name":        vciName,
+		"vci.flux.loft.sh/namespace":   vciNamespace,
+	})
+	if err := r.List(ctx, &list, &client.ListOptions{LabelSelector: sel}); err != nil {
+		return 0, err
+	}
+	updated := 0
+	for i := range list.Items {
+		s := &list.Items[i]
+		if v, ok := s.Labels["vci.flux.loft.sh/phase"]; ok && v == phase {
+			continue
+		}
+		s.Labels["vci.flux.loft.sh/phase"] = phase
+		if err := r.Update(ctx, s); client.IgnoreNotFound(err) != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
@@ -6,47 +6,98 @@ import (
 	"bytes"
 	"text/template"
 	"math/big"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/go-logr/logr"
+	"github.com/loft-demos/vcluster-platform-flux-secret-controller/internal/envelope"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	crlog "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 type Options struct {
-	LabelSelector            string
-	SecretKey                string
-	SecretPrefix             string
-	LoftDomain               string
-	ServerTemplate           string
-	CASecretNS               string
-	CASecretName             string
-	CASecretKey              string
-	FluxNamespacePatterns    []string
-	ControllerNamespace      string
-	PassthroughPrefixes      []string // (kept for compatibility; no longer used when copying all labels)
-	AccessKeyType            string // "User" or "Other"
-	AccessKeyTeam            string // e.g., "loft-admins"
-	AccessKeyDisplayNameTmpl string // e.g., "flux-{{ .Name }}"
+	LabelSelector             string
+	SecretKey                 string
+	SecretPrefix              string
+	LoftDomain                string
+	ServerTemplate            *template.Template   // parsed --server-template (see ParseServerTemplate)
+	CASecretNS                string
+	CASecretName              string
+	CASecretKey               string
+	FluxNamespacePatterns     []string
+	ControllerNamespace       string
+	PassthroughPrefixes       []string               // (kept for compatibility; no longer used when copying all labels)
+	AccessKeyType             string                 // "User" or "Other"
+	AccessKeyTeam             string                 // e.g., "loft-admins"
+	AccessKeyDisplayNameTmpl  string                 // e.g., "flux-{{ .Name }}"
+	PhasePolicy               map[string]PhaseAction // non-Ready phase -> keep|annotate|withdraw
+	TransitionalPhases        []string               // phases requeued after PhaseRequeueAfter
+	PhaseRequeueAfter         time.Duration
+	TokenRotationInterval     time.Duration        // 0 disables rotation
+	TokenRotationGrace        time.Duration        // how long the previous token stays valid
+	AccessKeyTTL              time.Duration        // 0 = AccessKeys never expire
+	AccessKeyRenewBefore      time.Duration        // renew this long before a TTL'd key expires
+	ExistingTokenSecret       string               // "[namespace/]name" of a Secret with a pre-existing token (BYO)
+	ExistingTokenSecretKey    string               // key in ExistingTokenSecret, default "token"
+	ExistingAccessKey         string               // name of a pre-existing AccessKey to read spec.key from (BYO)
+	AccessKeyUser             string               // owning user; takes precedence over AccessKeyTeam
+	AccessKeyUserFromLabel    string               // VCI label whose value names the owning user
+	AccessKeyTeamFromLabel    string               // VCI label whose value names the owning team
+	AccessKeyGroupsFromLabels []string             // VCI labels whose values become AccessKey groups
+	AccessKeyReadOnly         bool                 // restrict keys to get/list/watch
+	AccessKeyScopeRules       string               // JSON list of AccessKey scope rules
+	AccessKeyAllowedUsers     []string             // users the accesskey-user annotation may select
+	AccessKeyAllowedTeams     []string             // teams the accesskey-team annotation may select
+	TokenKeyProvider          envelope.KeyProvider // if set, token Secrets are envelope-encrypted
+	OutputKeys                []OutputKey          // Secret.data keys + encodings; empty = SecretKey as JSON
+	Kubeconfig                KubeconfigOptions    // proxy-url, tls-server-name, namespace, client certs, exec, extensions
+	ClusterNameTmpl           string               // Go template for the kubeconfig cluster name ("" = "loft")
+	ContextNameTmpl           string               // Go template for the kubeconfig context name ("" = "loft")
+	UserNameTmpl              string               // Go template for the kubeconfig user name ("" = "loft")
+	EndpointMode              string               // "proxy" (default) or "direct"
+	LocalClusterName          string               // platform name of the cluster this controller runs on ("" = any)
+	Outputs                   []string             // enabled output formats: flux (default), argocd
+	ArgoCDNamespacePatterns   []string             // namespaces for Argo CD cluster Secrets (globs OK, default "argocd")
+	ArgoCDClusterNameTmpl     string               // Go template for the Argo CD cluster name
+	CAPINamespacePatterns     []string             // namespaces for Cluster API kubeconfig Secrets (globs OK, default "default")
+	CAPIClusterNameTmpl       string               // Go template for the CAPI cluster name ("<cluster>-kubeconfig")
+	SecretTemplate            *SecretTemplate      // parsed --secret-template-file for the Flux output (nil = built-in shape)
+	BootstrapTemplates        BootstrapTemplates   // Flux bootstrap templates by name, selected per VCI by annotation
+	InputProviders            bool                 // publish a Flux Operator ResourceSetInputProvider per Flux Secret
+	VerifyMode                string               // "off" (default), "label" or "gate"
+	VerifyTimeout             time.Duration        // timeout for the SelfSubjectReview call
+	VerifyRetryAfter          time.Duration        // requeue delay after the first failed verification, doubled per failure
+	VerifyMaxRetryAfter       time.Duration        // cap for VerifyRetryAfter backoff
 }
 
 type VciReconciler struct {
 	client.Client
-	Log  logr.Logger
-	Opts Options
+	Log      logr.Logger
+	Opts     Options
+	Recorder record.EventRecorder
+
+	verified       sync.Map // VCI NamespacedName -> last kubeconfig checksum that passed verification
+	verifyFailures sync.Map // VCI NamespacedName -> consecutive failed verifications
 }
 
 func NewVciReconciler(c client.Client, log logr.Logger, opts Options) *VciReconciler {
@@ -75,12 +126,18 @@ func (r *VciReconciler) copyAllVCILabels(all map[string]string) map[string]strin
 	}
 	// keys we own and should never overwrite
 	reserved := map[string]struct{}{
-		"app.kubernetes.io/managed-by": {},
-		"fluxcd.io/kubeconfig":         {},
-		"fluxcd.io/secret-type":        {},
-		"vci.flux.loft.sh/name":        {},
-		"vci.flux.loft.sh/namespace":   {},
-		"vci.flux.loft.sh/project":     {},
+		"app.kubernetes.io/managed-by":   {},
+		"fluxcd.io/kubeconfig":           {},
+		"fluxcd.io/secret-type":          {},
+		"argocd.argoproj.io/secret-type": {},
+		"cluster.x-k8s.io/cluster-name":  {},
+		lblOutput:                        {},
+		lblInputProvider:                 {},
+		"vci.flux.loft.sh/name":          {},
+		"vci.flux.loft.sh/namespace":     {},
+		"vci.flux.loft.sh/project":       {},
+		"vci.flux.loft.sh/phase":         {},
+		lblVerified:                      {},
 	}
 	for k, v := range all {
 		// skip common system/app keys; everything else is copied
@@ -96,27 +153,93 @@ func (r *VciReconciler) copyAllVCILabels(all map[string]string) map[string]strin
 }
 
 func (r *VciReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("vcluster-platform-flux-secret-controller")
+	}
+
 	u := &unstructured.Unstructured{}
 	u.SetGroupVersionKind(gvkVCI)
+	ak := &unstructured.Unstructured{}
+	ak.SetGroupVersionKind(gvkAK)
 
 	// Label-based filtering predicate
 	pred := predicate.NewPredicateFuncs(func(o client.Object) bool {
 		if o == nil {
 		 return false
 		}
-		if r.Opts.LabelSelector == "" {
-			return true
-		}
-		sel, err := labels.Parse(r.Opts.LabelSelector)
-		if err != nil {
-			return true // if bad selector, don't block events
-		}
-		return sel.Matches(labels.Set(o.GetLabels()))
+		return r.matchesSelector(o.GetLabels())
 	})
+	// Also pass updates where the VCI stops matching, so its credentials get revoked
+	pred.UpdateFunc = func(e event.UpdateEvent) bool {
+		if e.ObjectOld == nil || e.ObjectNew == nil {
+			return false
+		}
+		return r.matchesSelector(e.ObjectOld.GetLabels()) || r.matchesSelector(e.ObjectNew.GetLabels())
+	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(u, builder.WithPredicates(pred)).
-		Complete(r)
+		Watches(&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.mapNamespaceToVCIs),
+			builder.WithPredicates(namespaceEventPredicate()),
+		).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.mapManagedObjectToVCI),
+			builder.WithPredicates(managedByPredicate()),
+		).
+		Watches(ak,
+			handler.EnqueueRequestsFromMapFunc(r.mapManagedObjectToVCI),
+			builder.WithPredicates(managedByPredicate()),
+		).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.mapCASecretToVCIs),
+			builder.WithPredicates(r.caSecretPredicate()),
+		).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.mapBYOObjectToVCIs),
+			builder.WithPredicates(r.byoCandidatePredicate()),
+		).
+		Watches(ak,
+			handler.EnqueueRequestsFromMapFunc(r.mapBYOObjectToVCIs),
+			builder.WithPredicates(r.byoCandidatePredicate()),
+		).
+		Watches(&corev1.Service{},
+			handler.EnqueueRequestsFromMapFunc(r.mapServiceToVCIs),
+			builder.WithPredicates(loadBalancerServicePredicate()),
+		)
+	// bootstrap objects are only watched when templates are configured, so the
+	// Flux CRDs are not required otherwise
+	if len(r.Opts.BootstrapTemplates) > 0 {
+		for _, gvk := range bootstrapKinds {
+			o := &unstructured.Unstructured{}
+			o.SetGroupVersionKind(gvk)
+			b = b.Watches(o,
+				handler.EnqueueRequestsFromMapFunc(r.mapManagedObjectToVCI),
+				builder.WithPredicates(managedByPredicate(), predicate.GenerationChangedPredicate{}),
+			)
+		}
+	}
+	if r.Opts.InputProviders {
+		o := &unstructured.Unstructured{}
+		o.SetGroupVersionKind(gvkInputProvider)
+		b = b.Watches(o,
+			handler.EnqueueRequestsFromMapFunc(r.mapManagedObjectToVCI),
+			builder.WithPredicates(managedByPredicate(), predicate.GenerationChangedPredicate{}),
+		)
+	}
+	return b.Complete(r)
+}
+
+// matchesSelector reports whether a VCI with the given labels is selected by --selector.
+func (r *VciReconciler) matchesSelector(lbls map[string]string) bool {
+	if r.Opts.LabelSelector == "" {
+		return true
+	}
+	sel, err := labels.Parse(r.Opts.LabelSelector)
+	if err != nil {
+		return true // if bad selector, don't block events
+	}
+	return sel.Matches(labels.Set(lbls))
 }
 
 func (r *VciReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -127,166 +250,270 @@ func (r *VciReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	vci.SetGroupVersionKind(gvkVCI)
 	if err := r.Get(ctx, req.NamespacedName, &vci); err != nil {
 		if apierrors.IsNotFound(err) {
-			// VCI deleted: GC secrets + AccessKey + token secret, with summary log
-			project := projectFromNamespace(req.Namespace)
-
-			secN, secErr := r.gcAllFluxSecretsForVCI(ctx, req.Namespace, req.Name)
-			akOK, akErr := r.deleteAccessKey(ctx, project, req.Name) // project-qualified AK name
-			tokOK, tokErr := r.deleteTokenSecret(ctx, req.Name)
-
-			crlog.FromContext(ctx).Info("cleanup after VCI delete",
-				"vci", req.NamespacedName.String(),
-				"project", project,
-				"secretsDeleted", secN,
-				"accessKeyDeleted", akOK,
-				"tokenSecretDeleted", tokOK,
-				"secErr", client.IgnoreNotFound(secErr),
-				"akErr", client.IgnoreNotFound(akErr),
-				"tokErr", client.IgnoreNotFound(tokErr),
-			)
-			return ctrl.Result{}, nil
+			// VCI already gone (e.g. deleted before the finalizer was added): best-effort GC
+			return r.revokeVCI(ctx, req.Namespace, req.Name)
 		}
 		return ctrl.Result{}, err
 	}
 
+	// VCI being deleted: revoke everything, then release the finalizer
+	if vci.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(&vci, vciFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if res, err := r.revokeVCI(ctx, vci.GetNamespace(), vci.GetName()); err != nil || !res.IsZero() {
+			return res, err
+		}
+		controllerutil.RemoveFinalizer(&vci, vciFinalizer)
+		return ctrl.Result{}, client.IgnoreNotFound(r.Update(ctx, &vci))
+	}
+
+	// VCI no longer matches --selector: revoke the same way as on deletion
+	if !r.matchesSelector(vci.GetLabels()) {
+		if res, err := r.revokeVCI(ctx, vci.GetNamespace(), vci.GetName()); err != nil || !res.IsZero() {
+			return res, err
+		}
+		if controllerutil.RemoveFinalizer(&vci, vciFinalizer) {
+			return ctrl.Result{}, client.IgnoreNotFound(r.Update(ctx, &vci))
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(&vci, vciFinalizer) {
+		controllerutil.AddFinalizer(&vci, vciFinalizer)
+		if err := r.Update(ctx, &vci); err != nil {
+			return ctrl.Result{}, fmt.Errorf("add finalizer: %w", err)
+		}
+	}
+
 	phase, _, _ := unstructured.NestedString(vci.Object, "status", "phase")
 	if phase != "Ready" {
-		log.Info("VCI not Ready yet", "phase", phase)
-		return ctrl.Result{}, nil
+		return r.reconcileNotReady(ctx, &vci, phase)
 	}
 
-	// 1) Ensure AccessKey + token Secret
-	token, err := r.ensureAccessKeyAndToken(ctx, &vci)
-	if err != nil {
+	// 1) Ensure AccessKey + token Secret, or read a bring-your-own credential
+	var (
+		token        string
+		requeueAfter time.Duration
+		err          error
+	)
+	if ref, ok := r.byoRefFor(&vci); ok {
+		if err := r.revokeGeneratedCredentials(ctx, vci.GetNamespace(), vci.GetName()); err != nil {
+			return ctrl.Result{}, fmt.Errorf("revoke generated credentials: %w", err)
+		}
+		if token, err = r.loadBYOToken(ctx, ref); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if token, requeueAfter, err = r.ensureAccessKeyAndToken(ctx, &vci); err != nil {
 		return ctrl.Result{}, fmt.Errorf("ensure access key: %w", err)
 	}
 
 	// 2) Build kubeconfig bytes
 	project := projectFromNamespace(vci.GetNamespace())
-	serverURL, err := renderServerURL(r.Opts.ServerTemplate, serverVars{
-		Domain:    r.Opts.LoftDomain,
-		Project:   project,
-		Namespace: vci.GetNamespace(),
-		Name:      vci.GetName(),
-	})
+	if r.Opts.ServerTemplate == nil {
+		return ctrl.Result{}, fmt.Errorf("no server template configured")
+	}
+	serverURL, err := renderServerURL(r.Opts.ServerTemplate, r.serverVarsFor(&vci))
 	if err != nil {
 		return ctrl.Result{}, fmt.Errorf("render server url: %w", err)
 	}
 
-	var caPEM []byte
-	if r.Opts.CASecretNS != "" && r.Opts.CASecretName != "" {
-		var ca corev1.Secret
-		if err := r.Get(ctx, types.NamespacedName{Name: r.Opts.CASecretName, Namespace: r.Opts.CASecretNS}, &ca); err == nil {
-			caPEM = ca.Data[r.Opts.CASecretKey]
+	caPEM, err := r.loadCAPEM(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	endpointMode, err := r.endpointModeFor(&vci)
+	if err != nil {
+		r.Recorder.Event(&vci, corev1.EventTypeWarning, "InvalidEndpointMode", err.Error())
+		log.Info("not publishing", "err", err.Error())
+		return ctrl.Result{}, nil
+	}
+	if endpointMode == EndpointModeDirect {
+		srv, ca, ok, err := r.directEndpoint(ctx, &vci)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("discover direct endpoint: %w", err)
+		}
+		if ok {
+			serverURL, caPEM = srv, ca
+		} else {
+			log.Info("no direct vCluster endpoint exposed, using platform proxy", "server", serverURL)
 		}
 	}
 
-	kcfgBytes, ksum, err := buildKubeconfigBytes(serverURL, vci.GetName(), token, caPEM)
+	names, kcfgOpts, err := r.kubeconfigNamesFor(&vci)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("render kubeconfig names: %w", err)
+	}
+	kcfgBytes, ksum, err := buildKubeconfigBytes(serverURL, names, token, caPEM, kcfgOpts)
 	if err != nil {
 		return ctrl.Result{}, fmt.Errorf("build kubeconfig: %w", err)
 	}
 
-	// 3) Resolve Flux namespaces (exact + globs) and upsert per-NS secrets
-	nsList, err := r.resolveFluxNamespaces(ctx)
+	// 3) Optionally verify the kubeconfig against the server before publishing
+	mode, err := r.verifyModeFor(&vci)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("resolve namespaces: %w", err)
+		r.Recorder.Event(&vci, corev1.EventTypeWarning, "InvalidVerifyMode", err.Error())
+		log.Info("not publishing", "err", err.Error())
+		return ctrl.Result{}, nil
 	}
-	for _, ns := range nsList {
-		if err := r.upsertFluxSecretInNS(ctx, &vci, project, ns, kcfgBytes, ksum); err != nil {
-			return ctrl.Result{}, fmt.Errorf("upsert secret in %s: %w", ns, err)
+	var verified *bool
+	if mode != VerifyOff {
+		fresh, verr := r.verifyKubeconfig(ctx, req.NamespacedName, kcfgBytes, ksum)
+		ok := verr == nil
+		verified = &ok
+		switch {
+		case verr != nil:
+			retry := r.verifyBackoff(req.NamespacedName)
+			r.Recorder.Eventf(&vci, corev1.EventTypeWarning, "KubeconfigUnverified",
+				"kubeconfig for %s failed verification: %v", serverURL, verr)
+			if mode == VerifyGate {
+				log.Info("kubeconfig not verified, not publishing", "server", serverURL, "retryAfter", retry, "err", verr.Error())
+				return ctrl.Result{RequeueAfter: retry}, nil
+			}
+			if requeueAfter == 0 || retry < requeueAfter {
+				requeueAfter = retry
+			}
+		case fresh:
+			r.Recorder.Eventf(&vci, corev1.EventTypeNormal, "KubeconfigVerified", "kubeconfig for %s verified", serverURL)
 		}
 	}
 
-	log.Info("reconciled VCI", "namespaces", strings.Join(nsList, ","))
-	return ctrl.Result{}, nil
-}
+	// 4) Render each enabled output format and upsert it into its namespaces
+	in := &publishInput{
+		VCI:        &vci,
+		Project:    project,
+		Server:     serverURL,
+		Token:      token,
+		CAPEM:      caPEM,
+		Names:      names,
+		Kubeconfig: kcfgOpts,
+		KcfgJSON:   kcfgBytes,
+		Sum:        ksum,
+	}
+	keep := map[types.NamespacedName]struct{}{}
+	fluxSecrets := map[string]fluxSecretRef{} // Flux namespace -> kubeconfig Secret, for bootstrap objects and input providers
+	var published []string
+	for _, f := range r.outputFormats() {
+		nsList, err := f.Namespaces(ctx)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("resolve %s namespaces: %w", f.Name(), err)
+		}
+		out, err := f.Render(in)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("render %s secret: %w", f.Name(), err)
+		}
+		for _, ns := range nsList {
+			if err := r.upsertOutputSecret(ctx, in, f.Name(), ns, out, verified); err != nil {
+				return ctrl.Result{}, fmt.Errorf("upsert %s secret in %s: %w", f.Name(), ns, err)
+			}
+			keep[types.NamespacedName{Namespace: ns, Name: out.Name}] = struct{}{}
+			published = append(published, f.Name()+":"+ns)
+			if f.Name() == OutputFlux {
+				fluxSecrets[ns] = fluxSecretRef{Name: out.Name, Key: out.KubeconfigKey}
+			}
+		}
+	}
 
-// ----- helpers -----
+	// 5) Prune Secrets from namespaces (or outputs) that are no longer selected
+	pruned, pruneErr := r.pruneOutputSecretsExcept(ctx, vci.GetNamespace(), vci.GetName(), keep)
+	if pruned > 0 || pruneErr != nil {
+		log.Info("cleanup after namespace change",
+			"secretsDeleted", pruned,
+			"secErr", pruneErr,
+		)
+	}
+	if pruneErr != nil {
+		return ctrl.Result{}, fmt.Errorf("prune secrets: %w", pruneErr)
+	}
 
-func (r *VciReconciler) upsertFluxSecretInNS(
-    ctx context.Context,
-    vci *unstructured.Unstructured,
-    project, ns string,
-    kcfg []byte,
-    sumHex string,
-) error {
-    name := r.secretNameFor(project, vci.GetName())
-    k := r.Opts.SecretKey
-    want := map[string][]byte{k: kcfg}
-
-    // base labels we always set
-    lbl := map[string]string{
-        "app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
-        "fluxcd.io/kubeconfig":         "true",
-        "fluxcd.io/secret-type":        "cluster",
-        "vci.flux.loft.sh/name":        vci.GetName(),
-        "vci.flux.loft.sh/namespace":   vci.GetNamespace(),
-        "vci.flux.loft.sh/project":     project,
-    }
-    // merge ALL user labels from VCI (minus reserved/system)
-    for k2, v2 := range r.copyAllVCILabels(vci.GetLabels()) {
-        if _, reserved := lbl[k2]; !reserved {
-            lbl[k2] = v2
-        }
-    }
+	// 6) Render Flux Kustomization/HelmRelease bootstrap objects next to the Secrets
+	if len(r.Opts.BootstrapTemplates) > 0 {
+		bsPruned, err := r.reconcileBootstrap(ctx, &vci, fluxSecrets)
+		if err != nil {
+			r.Recorder.Event(&vci, corev1.EventTypeWarning, "BootstrapFailed", err.Error())
+			return ctrl.Result{}, fmt.Errorf("bootstrap objects: %w", err)
+		}
+		if bsPruned > 0 {
+			log.Info("pruned bootstrap objects", "deleted", bsPruned)
+		}
+	}
 
-    ann := map[string]string{
-        "vci.flux.loft.sh/kcfg-sha256": sumHex,
-    }
+	// 7) Publish ResourceSet inputs for the Flux Operator
+	if r.Opts.InputProviders {
+		ipPruned, err := r.reconcileInputProviders(ctx, in, fluxSecrets)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("input providers: %w", err)
+		}
+		if ipPruned > 0 {
+			log.Info("pruned input providers", "deleted", ipPruned)
+		}
+	}
 
-    var existing corev1.Secret
-    if err := r.Get(ctx, types.NamespacedName{Namespace: ns, Name: name}, &existing); err != nil {
-        if apierrors.IsNotFound(err) {
-            sec := corev1.Secret{
-                ObjectMeta: meta.ObjectMeta{
-                    Name:        name,
-                    Namespace:   ns,
-                    Labels:      lbl,
-                    Annotations: ann,
-                },
-                Type: corev1.SecretTypeOpaque,
-                Data: want,
-            }
-            return r.Create(ctx, &sec)
-        }
-        return err
-    }
+	log.Info("reconciled VCI", "secrets", strings.Join(published, ","))
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
 
-    // ---- UPDATE path: detect drift in data, annotations, OR labels ----
-    dataChanged := base64.StdEncoding.EncodeToString(existing.Data[k]) != base64.StdEncoding.EncodeToString(want[k])
-    annChanged := existing.Annotations == nil || existing.Annotations["vci.flux.loft.sh/kcfg-sha256"] != sumHex
+// ----- helpers -----
 
-    // build the would-be label map and compare
-    desiredLabels := map[string]string{}
-    for k2, v2 := range existing.Labels { // start from existing to preserve unrelated keys you might want to keep
-        desiredLabels[k2] = v2
-    }
-    for k2, v2 := range lbl { // ensure our desired keys/values are present
-        desiredLabels[k2] = v2
-    }
-    labelsChanged := !equalStringMap(existing.Labels, desiredLabels)
+// kubeconfigNamesFor renders the cluster/context/user names and per-VCI kubeconfig
+// options. VCI annotations override the global templates and default namespace.
+func (r *VciReconciler) kubeconfigNamesFor(vci *unstructured.Unstructured) (kubeconfigNames, KubeconfigOptions, error) {
+	ann := vci.GetAnnotations()
+	vars := r.nameVarsFor(vci)
+	pick := func(annKey, def string) string {
+		if v := ann[annKey]; v != "" {
+			return v
+		}
+		return def
+	}
 
-    if dataChanged || annChanged || labelsChanged {
-        existing.Data = want
+	var names kubeconfigNames
+	var err error
+	if names.Cluster, err = renderKubeconfigName(pick("vci.flux.loft.sh/kubeconfig-cluster-name", r.Opts.ClusterNameTmpl), vars); err != nil {
+		return names, KubeconfigOptions{}, err
+	}
+	if names.Context, err = renderKubeconfigName(pick("vci.flux.loft.sh/kubeconfig-context-name", r.Opts.ContextNameTmpl), vars); err != nil {
+		return names, KubeconfigOptions{}, err
+	}
+	if names.User, err = renderKubeconfigName(pick("vci.flux.loft.sh/kubeconfig-user-name", r.Opts.UserNameTmpl), vars); err != nil {
+		return names, KubeconfigOptions{}, err
+	}
 
-        if existing.Annotations == nil {
-            existing.Annotations = map[string]string{}
-        }
-        existing.Annotations["vci.flux.loft.sh/kcfg-sha256"] = sumHex
+	opts := r.Opts.Kubeconfig
+	opts.Namespace = pick("vci.flux.loft.sh/kubeconfig-namespace", opts.Namespace)
+	return names, opts, nil
+}
 
-        if existing.Labels == nil {
-            existing.Labels = map[string]string{}
-        }
-        for k2, v2 := range desiredLabels {
-            existing.Labels[k2] = v2
-        }
+// outputKeys returns the configured Secret.data keys, defaulting to --secret-key as JSON.
+func (r *VciReconciler) outputKeys() []OutputKey {
+	if len(r.Opts.OutputKeys) > 0 {
+		return r.Opts.OutputKeys
+	}
+	return []OutputKey{{Key: r.Opts.SecretKey, Encoding: "json"}}
+}
 
-        return r.Update(ctx, &existing)
-    }
-    return nil
+// loadCAPEM returns the custom CA PEM, or nil if no CA Secret is configured.
+// A configured but missing Secret or key is an error, not a silent omission.
+func (r *VciReconciler) loadCAPEM(ctx context.Context) ([]byte, error) {
+	if r.Opts.CASecretNS == "" || r.Opts.CASecretName == "" {
+		return nil, nil
+	}
+	var ca corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Name: r.Opts.CASecretName, Namespace: r.Opts.CASecretNS}, &ca); err != nil {
+		return nil, fmt.Errorf("get CA secret %s/%s: %w", r.Opts.CASecretNS, r.Opts.CASecretName, err)
+	}
+	pem, ok := ca.Data[r.Opts.CASecretKey]
+	if !ok || len(pem) == 0 {
+		return nil, fmt.Errorf("CA secret %s/%s has no key %q", r.Opts.CASecretNS, r.Opts.CASecretName, r.Opts.CASecretKey)
+	}
+	return pem, nil
 }
 
-// return number of secrets deleted
+// gcAllFluxSecretsForVCI deletes every Secret published for the VCI. Bootstrap
+// objects and input providers are left alone, so a phase withdraw does not
+// uninstall what they deployed; revokeVCI deletes those first.
+// Returns number of secrets deleted.
 func (r *VciReconciler) gcAllFluxSecretsForVCI(ctx context.Context, vciNamespace, vciName string) (int, error) {
 	var list corev1.SecretList
 	sel := labels.SelectorFromSet(map[string]string{
@@ -298,12 +525,15 @@ func (r *VciReconciler) gcAllFluxSecretsForVCI(ctx context.Context, vciNamespace
 		return 0, err
 	}
 	deleted := 0
+	var errs []error
 	for i := range list.Items {
-		if err := r.Delete(ctx, &list.Items[i]); client.IgnoreNotFound(err) == nil {
-			deleted++
+		if err := r.Delete(ctx, &list.Items[i]); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, err)
+			continue
 		}
+		deleted++
 	}
-	return deleted, nil
+	return deleted, utilerrors.NewAggregate(errs)
 }
 
 func (r *VciReconciler) deleteAccessKey(ctx context.Context, project, vciName string) (bool, error) {
@@ -317,10 +547,10 @@ func (r *VciReconciler) deleteAccessKey(ctx context.Context, project, vciName st
 	return err == nil, err
 }
 
-func (r *VciReconciler) deleteTokenSecret(ctx context.Context, vciName string) (bool, error) {
+func (r *VciReconciler) deleteTokenSecret(ctx context.Context, vciNamespace, vciName string) (bool, error) {
 	s := &corev1.Secret{
 		ObjectMeta: meta.ObjectMeta{
-			Name:      tokenSecretName(r.Opts.SecretPrefix, vciName),
+			Name:      tokenSecretName(r.Opts.SecretPrefix, projectFromNamespace(vciNamespace), vciNamespace, vciName),
 			Namespace: r.Opts.ControllerNamespace,
 		},
 	}
@@ -331,31 +561,224 @@ func (r *VciReconciler) deleteTokenSecret(ctx context.Context, vciName string) (
 	return err == nil, err
 }
 
-func (r *VciReconciler) ensureAccessKeyAndToken(ctx context.Context, vci *unstructured.Unstructured) (string, error) {
+func (r *VciReconciler) ensureAccessKeyAndToken(ctx context.Context, vci *unstructured.Unstructured) (string, time.Duration, error) {
+	now := time.Now().UTC()
+
 	// 0) Load or mint token (64-char alnum)
 	var token string
 	var tokSec corev1.Secret
-	tokName := tokenSecretName(r.Opts.SecretPrefix, vci.GetName())
-	if err := r.Get(ctx, types.NamespacedName{Name: tokName, Namespace: r.Opts.ControllerNamespace}, &tokSec); err == nil {
-		if b, ok := tokSec.Data["token"]; ok && len(b) > 0 {
-			token = string(b)
+	tokName := tokenSecretName(r.Opts.SecretPrefix, projectFromNamespace(vci.GetNamespace()), vci.GetNamespace(), vci.GetName())
+	tokAnns := map[string]string{}
+	found, legacy, err := r.getTokenSecret(ctx, vci, tokName, &tokSec)
+	if err != nil {
+		return "", 0, err
+	}
+	if found {
+		t, err := r.readStoredToken(ctx, &tokSec)
+		switch {
+		case errors.Is(err, errTokenUnreadable):
+			// the key is gone for good: issue a new token rather than fail forever
+			r.Log.Error(err, "re-issuing unreadable token", "vci", vci.GetNamespace()+"/"+vci.GetName())
+			r.Recorder.Event(vci, corev1.EventTypeWarning, "TokenReissued", err.Error())
+		case err != nil:
+			return "", 0, err
+		}
+		token = t
+		for _, k := range []string{annTokenIssuedAt, annTokenRotatedAt} {
+			if v, ok := tokSec.Annotations[k]; ok {
+				tokAnns[k] = v
+			}
 		}
 	}
-	if token == "" {
+	issuedAt := parseTimeOr(tokAnns[annTokenIssuedAt], tokSec.CreationTimestamp.Time)
+	interval := r.tokenRotationInterval(vci)
+	if renew := r.accessKeyRenewAfter(); renew > 0 && (interval == 0 || renew < interval) {
+		// a TTL'd key must be renewed before it expires, whatever the rotation interval
+		interval = renew
+	}
+
+	var prevToken string
+	if token == "" || (interval > 0 && !now.Before(issuedAt.Add(interval))) {
 		t, err := randomToken(64) // 64 chars
 		if err != nil {
-			return "", err
+			return "", 0, err
+		}
+		if token != "" {
+			prevToken = token
+			tokAnns[annTokenRotatedAt] = now.Format(time.RFC3339)
 		}
 		token = t
+		issuedAt = now
+		tokAnns[annTokenIssuedAt] = now.Format(time.RFC3339)
 	}
 
 	// compute project FIRST so we can name the AK correctly
 	project := projectFromNamespace(vci.GetNamespace())
 
-	// 1) Upsert AccessKey with "User" shape (team + displayName), scoped to this VCI
+	// 1) On rotation keep the previous token valid for the grace period. The
+	// -previous AccessKey is created before the main key switches tokens, so the
+	// old token is never invalid in between (and stays valid if this fails).
+	if prevToken != "" && r.Opts.TokenRotationGrace > 0 {
+		expires := now.Add(r.Opts.TokenRotationGrace).Format(time.RFC3339)
+		if err := r.upsertAccessKey(ctx, vci, previousAccessKeyName(project, vci.GetName()), prevToken,
+			r.Opts.TokenRotationGrace, map[string]string{annPreviousTokenExpiresAt: expires}); err != nil {
+			return "", 0, err
+		}
+		tokAnns[annPreviousTokenExpiresAt] = expires
+	}
+
+	// 1b) Upsert AccessKey scoped to this VCI
+	if err := r.upsertAccessKey(ctx, vci, accessKeyName(project, vci.GetName()), token, r.Opts.AccessKeyTTL, nil); err != nil {
+		return "", 0, err
+	}
+	if r.Opts.AccessKeyTTL > 0 {
+		tokAnns[annAccessKeyExpiresAt] = issuedAt.Add(r.Opts.AccessKeyTTL).Format(time.RFC3339)
+	}
+
+	// 1c) Carry the previous key's expiry forward and drop it once expired
+	if v, ok := tokSec.Annotations[annPreviousTokenExpiresAt]; ok && tokAnns[annPreviousTokenExpiresAt] == "" {
+		tokAnns[annPreviousTokenExpiresAt] = v
+	}
+	if v, ok := tokAnns[annPreviousTokenExpiresAt]; ok && !now.Before(parseTimeOr(v, now)) {
+		if _, err := r.deletePreviousAccessKey(ctx, project, vci.GetName()); err != nil {
+			return "", 0, err
+		}
+		delete(tokAnns, annPreviousTokenExpiresAt)
+	}
+
+	// 2) Persist/refresh token Secret (envelope-encrypted if a key is configured)
+	tokData, kekID, err := r.tokenSecretData(ctx, &tokSec, token)
+	if err != nil {
+		return "", 0, err
+	}
+	if kekID != "" {
+		tokAnns[annTokenKEKID] = kekID
+	}
+	tokAnns["vci.flux.loft.sh/vci"] = fmt.Sprintf("%s/%s", vci.GetNamespace(), vci.GetName())
+	save := corev1.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      tokName,
+			Namespace: r.Opts.ControllerNamespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
+			},
+			Annotations: tokAnns,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: tokData,
+	}
+	if err := r.Create(ctx, &save); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			if e2 := r.Get(ctx, types.NamespacedName{Name: tokName, Namespace: r.Opts.ControllerNamespace}, &tokSec); e2 == nil {
+				before := tokSec.DeepCopy()
+				tokSec.Data = tokData
+				if tokSec.Labels == nil {
+					tokSec.Labels = map[string]string{}
+				}
+				tokSec.Labels["app.kubernetes.io/managed-by"] = "vcluster-platform-flux-secret-controller"
+				if tokSec.Annotations == nil {
+					tokSec.Annotations = map[string]string{}
+				}
+				delete(tokSec.Annotations, annPreviousTokenExpiresAt)
+				delete(tokSec.Annotations, annAccessKeyExpiresAt)
+				delete(tokSec.Annotations, annTokenKEKID)
+				for k, v := range tokAnns {
+					tokSec.Annotations[k] = v
+				}
+				if !equality.Semantic.DeepEqual(before, &tokSec) {
+					if e3 := r.Update(ctx, &tokSec); e3 != nil {
+						r.Log.Error(e3, "failed to update token Secret", "name", tokName)
+						return "", 0, e3
+					}
+				}
+			}
+		} else {
+			r.Log.Error(err, "failed to create token Secret", "name", tokName)
+			return "", 0, err
+		}
+	}
+
+	// token carried over from a legacy-named Secret: the new Secret now holds it
+	if legacy {
+		if err := r.Delete(ctx, &corev1.Secret{ObjectMeta: meta.ObjectMeta{
+			Name: legacyTokenSecretName(r.Opts.SecretPrefix, vci.GetName()), Namespace: r.Opts.ControllerNamespace,
+		}}); client.IgnoreNotFound(err) != nil {
+			r.Log.Error(err, "failed to delete legacy token Secret", "vci", vci.GetNamespace()+"/"+vci.GetName())
+		}
+	}
+
+	// Always visible
+	pol, err := r.accessKeyPolicyFor(vci)
+	if err != nil {
+		return "", 0, err
+	}
+	r.Log.Info("AccessKey ensured",
+		"displayName", renderDisplayName(r.Opts.AccessKeyDisplayNameTmpl, vci.GetName(), project, vci.GetNamespace()),
+		"user", pol.User,
+		"team", pol.Team,
+		"groups", pol.Groups,
+		"readOnly", pol.ReadOnly,
+		"project", project,
+		"rotated", prevToken != "",
+		"tokenPrefix", func() string {
+			if len(token) >= 6 {
+				return token[:6]
+			}
+			return ""
+		}(),
+	)
+
+	// 3) Requeue for the next rotation or previous-key expiry, whichever is first
+	var requeue time.Duration
+	if interval > 0 {
+		requeue = issuedAt.Add(interval).Sub(now)
+	}
+	if v, ok := tokAnns[annPreviousTokenExpiresAt]; ok {
+		if d := parseTimeOr(v, now).Sub(now); requeue == 0 || d < requeue {
+			requeue = d
+		}
+	}
+	if requeue < 0 {
+		requeue = time.Second
+	}
+	return token, requeue, nil
+}
+
+// getTokenSecret loads the VCI's token Secret into s. If it does not exist yet, a
+// legacy-named Secret belonging to this VCI is loaded instead (legacy=true), so a
+// reconcile that runs before the startup migration keeps the existing token.
+func (r *VciReconciler) getTokenSecret(ctx context.Context, vci *unstructured.Unstructured, name string, s *corev1.Secret) (found, legacy bool, err error) {
+	err = r.Get(ctx, types.NamespacedName{Name: name, Namespace: r.Opts.ControllerNamespace}, s)
+	if err == nil {
+		return true, false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, false, fmt.Errorf("get token Secret %s: %w", name, err)
+	}
+	legacyName := legacyTokenSecretName(r.Opts.SecretPrefix, vci.GetName())
+	var old corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Name: legacyName, Namespace: r.Opts.ControllerNamespace}, &old); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("get legacy token Secret %s: %w", legacyName, err)
+	}
+	if old.Annotations["vci.flux.loft.sh/vci"] != vci.GetNamespace()+"/"+vci.GetName() {
+		return false, false, nil
+	}
+	*s = old
+	return true, true, nil
+}
+
+// upsertAccessKey creates or updates the AccessKey with "User" shape (team + displayName),
+// scoped to this VCI and holding the given key. With ttl > 0 the key expires ttl after
+// creation, so a changed key or ttl recreates the AccessKey to restart its lifetime.
+func (r *VciReconciler) upsertAccessKey(ctx context.Context, vci *unstructured.Unstructured, name, token string, ttl time.Duration, extraAnns map[string]string) error {
+	project := projectFromNamespace(vci.GetNamespace())
+
 	ak := unstructured.Unstructured{}
 	ak.SetGroupVersionKind(gvkAK)
-	ak.SetName(accessKeyName(project, vci.GetName()))
+	ak.SetName(name)
 
 	display := renderDisplayName(r.Opts.AccessKeyDisplayNameTmpl, vci.GetName(), project, vci.GetNamespace())
 
@@ -376,8 +799,15 @@ func (r *VciReconciler) ensureAccessKeyAndToken(ctx context.Context, vci *unstru
 			},
 		},
 	}
-	if strings.EqualFold(akType, "User") && r.Opts.AccessKeyTeam != "" {
-		spec["team"] = r.Opts.AccessKeyTeam
+	pol, err := r.accessKeyPolicyFor(vci)
+	if err != nil {
+		return err
+	}
+	if err := pol.applyTo(spec, akType, vcTargetFor(vci)); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		spec["ttl"] = int64(ttl.Seconds())
 	}
 
 	// Branding labels/annotations (keep for debugging/ownership)
@@ -390,17 +820,41 @@ func (r *VciReconciler) ensureAccessKeyAndToken(ctx context.Context, vci *unstru
 	brandAnns := map[string]string{
 		"vci.flux.loft.sh/vci": fmt.Sprintf("%s/%s", vci.GetNamespace(), vci.GetName()),
 	}
+	for k, v := range extraAnns {
+		brandAnns[k] = v
+	}
 
 	// Upsert
-	if err := r.Get(ctx, types.NamespacedName{Name: ak.GetName()}, &ak); apierrors.IsNotFound(err) {
+	err = r.Get(ctx, types.NamespacedName{Name: ak.GetName()}, &ak)
+	if apierrors.IsNotFound(err) {
 		ak.SetLabels(brandLabels)
 		ak.SetAnnotations(brandAnns)
 		_ = unstructured.SetNestedField(ak.Object, spec, "spec")
 		if err := r.Create(ctx, &ak); err != nil {
 			r.Log.Error(err, "failed to create AccessKey", "name", ak.GetName())
-			return "", err
+			return err
 		}
+		return r.ensureScopeStored(ctx, &ak)
 	} else if err == nil {
+		if ttl > 0 && accessKeyNeedsRenewal(&ak, token, ttl) {
+			// lifetime counts from creation: replace the object instead of updating it
+			if err := r.Delete(ctx, &ak); client.IgnoreNotFound(err) != nil {
+				r.Log.Error(err, "failed to delete AccessKey for renewal", "name", ak.GetName())
+				return err
+			}
+			fresh := unstructured.Unstructured{}
+			fresh.SetGroupVersionKind(gvkAK)
+			fresh.SetName(name)
+			fresh.SetLabels(brandLabels)
+			fresh.SetAnnotations(brandAnns)
+			_ = unstructured.SetNestedField(fresh.Object, spec, "spec")
+			if err := r.Create(ctx, &fresh); err != nil {
+				r.Log.Error(err, "failed to recreate AccessKey", "name", name)
+				return err
+			}
+			return r.ensureScopeStored(ctx, &fresh)
+		}
+		before := ak.DeepCopy()
 		_ = unstructured.SetNestedField(ak.Object, spec, "spec")
 		lbl := ak.GetLabels()
 		if lbl == nil {
@@ -418,66 +872,29 @@ func (r *VciReconciler) ensureAccessKeyAndToken(ctx context.Context, vci *unstru
 			ann[k] = v
 		}
 		ak.SetAnnotations(ann)
-		if err := r.Update(ctx, &ak); err != nil {
-			r.Log.Error(err, "failed to update AccessKey", "name", ak.GetName())
-			return "", err
+		// skip no-op updates so they don't feed back into our own watch
+		if !equality.Semantic.DeepEqual(before.Object, ak.Object) {
+			if err := r.Update(ctx, &ak); err != nil {
+				r.Log.Error(err, "failed to update AccessKey", "name", ak.GetName())
+				return err
+			}
 		}
-	} else {
-		r.Log.Error(err, "failed to GET AccessKey", "name", ak.GetName())
-		return "", err
+		return r.ensureScopeStored(ctx, &ak)
 	}
+	r.Log.Error(err, "failed to GET AccessKey", "name", ak.GetName())
+	return err
+}
 
-	// 2) Persist/refresh token Secret
-	save := corev1.Secret{
-		ObjectMeta: meta.ObjectMeta{
-			Name:      tokName,
-			Namespace: r.Opts.ControllerNamespace,
-			Labels: map[string]string{
-				"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller",
-			},
-			Annotations: map[string]string{
-				"vci.flux.loft.sh/vci": fmt.Sprintf("%s/%s", vci.GetNamespace(), vci.GetName()),
-			},
-		},
-		Type: corev1.SecretTypeOpaque,
-		Data: map[string][]byte{"token": []byte(token)},
+// ensureScopeStored deletes an AccessKey whose scope rules lost their virtual
+// cluster pin when stored (see scopeRulesPinned), so it fails closed.
+func (r *VciReconciler) ensureScopeStored(ctx context.Context, ak *unstructured.Unstructured) error {
+	if scopeRulesPinned(ak) {
+		return nil
 	}
-	if err := r.Create(ctx, &save); err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			if e2 := r.Get(ctx, types.NamespacedName{Name: tokName, Namespace: r.Opts.ControllerNamespace}, &tokSec); e2 == nil {
-				if tokSec.Data == nil {
-					tokSec.Data = map[string][]byte{}
-				}
-				tokSec.Data["token"] = []byte(token)
-				if tokSec.Annotations == nil {
-					tokSec.Annotations = map[string]string{}
-				}
-				tokSec.Annotations["vci.flux.loft.sh/vci"] = fmt.Sprintf("%s/%s", vci.GetNamespace(), vci.GetName())
-				if e3 := r.Update(ctx, &tokSec); e3 != nil {
-					r.Log.Error(e3, "failed to update token Secret", "name", tokName)
-					return "", e3
-				}
-			}
-		} else {
-			r.Log.Error(err, "failed to create token Secret", "name", tokName)
-			return "", err
-		}
+	if err := r.Delete(ctx, ak); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("delete AccessKey %s with unpinned scope rules: %w", ak.GetName(), err)
 	}
-
-	// Always visible
-	r.Log.Info("AccessKey ensured (User/team style)",
-		"displayName", display,
-		"team", r.Opts.AccessKeyTeam,
-		"project", project,
-		"tokenPrefix", func() string {
-			if len(token) >= 6 {
-				return token[:6]
-			}
-			return ""
-		}(),
-	)
-
-	return token, nil
+	return fmt.Errorf("AccessKey %s: the platform dropped scope.rules[].virtualClusters; deleted the key instead of publishing a wider one", ak.GetName())
 }
 
 func randomToken(n int) (string, error) {
@@ -498,7 +915,13 @@ func (r *VciReconciler) secretNameFor(project, vciName string) string {
 	return fmt.Sprintf("%s%s-%s-kubeconfig", r.Opts.SecretPrefix, project, vciName)
 }
 
-func tokenSecretName(prefix, vciName string) string {
+// tokenSecretName includes project and VCI namespace so same-named VCIs never share a token.
+func tokenSecretName(prefix, project, vciNamespace, vciName string) string {
+	return fmt.Sprintf("%s%s-%s-%s-ak", prefix, project, vciNamespace, vciName)
+}
+
+// legacyTokenSecretName is the pre-project-qualified name, kept for migration only.
+func legacyTokenSecretName(prefix, vciName string) string {
 	return fmt.Sprintf("%s%s-ak", prefix, vciName)
 }
 
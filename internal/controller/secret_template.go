@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// secretTemplateSpec is the --secret-template-file format. Every string is a Go
+// template rendered with secretTemplateVars and the --server-template functions.
+//
+//	name: "{{ .Project }}-{{ .Name }}-kubeconfig"
+//	type: Opaque
+//	labels:
+//	  team: '{{ .Labels.team | default "platform" }}'
+//	annotations:
+//	  example.com/server: "{{ .Server }}"
+//	data:
+//	  value.yaml: "{{ .KubeconfigYAML }}"
+//	  ca.crt: "{{ .CA }}"
+//	kubeconfigKey: value.yaml
+type secretTemplateSpec struct {
+	Name        string            `json:"name,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Data        map[string]string `json:"data,omitempty"`
+	// KubeconfigKey names the data key holding the kubeconfig, for bootstrap
+	// objects and input providers. Defaults to the only data key.
+	KubeconfigKey string `json:"kubeconfigKey,omitempty"`
+}
+
+// secretTemplateVars is the context for --secret-template-file templates.
+type secretTemplateVars struct {
+	serverVars
+	Server         string
+	Token          string
+	CA             string // PEM, empty if none
+	Kubeconfig     string // JSON
+	KubeconfigYAML string
+}
+
+// SecretTemplate is a parsed --secret-template-file.
+type SecretTemplate struct {
+	name        *template.Template
+	typ         string
+	labels      map[string]*template.Template
+	annotations map[string]*template.Template
+	data        map[string]*template.Template
+	kcfgKey     string
+}
+
+// LoadSecretTemplate reads and parses a Secret template file (typically a mounted
+// ConfigMap) and renders it once against sample values, so mistakes surface at startup.
+func LoadSecretTemplate(path string) (*SecretTemplate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read secret template: %w", err)
+	}
+	var spec secretTemplateSpec
+	if err := yaml.UnmarshalStrict(raw, &spec); err != nil {
+		return nil, fmt.Errorf("parse secret template %s: %w", path, err)
+	}
+
+	parse := func(what, s string) (*template.Template, error) {
+		t, err := template.New(what).Funcs(serverTemplateFuncs).Option("missingkey=zero").Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("secret template %s: %w", what, err)
+		}
+		return t, nil
+	}
+	parseMap := func(what string, in map[string]string) (map[string]*template.Template, error) {
+		out := make(map[string]*template.Template, len(in))
+		for k, v := range in {
+			t, err := parse(what+" "+k, v)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = t
+		}
+		return out, nil
+	}
+
+	st := &SecretTemplate{typ: spec.Type}
+	if spec.Name != "" {
+		if st.name, err = parse("name", spec.Name); err != nil {
+			return nil, err
+		}
+	}
+	for k := range spec.Labels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, fmt.Errorf("secret template label %q: %s", k, strings.Join(errs, "; "))
+		}
+		if k == "app.kubernetes.io/managed-by" || strings.HasPrefix(k, "vci.flux.loft.sh/") {
+			return nil, fmt.Errorf("secret template label %q is reserved for the controller", k)
+		}
+	}
+	for k := range spec.Data {
+		if errs := validation.IsConfigMapKey(k); len(errs) > 0 {
+			return nil, fmt.Errorf("secret template data key %q: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	st.kcfgKey = spec.KubeconfigKey
+	if st.kcfgKey != "" {
+		if _, ok := spec.Data[st.kcfgKey]; !ok {
+			return nil, fmt.Errorf("secret template kubeconfigKey %q is not a data key", st.kcfgKey)
+		}
+	} else if len(spec.Data) == 1 {
+		for k := range spec.Data {
+			st.kcfgKey = k
+		}
+	}
+	if st.labels, err = parseMap("label", spec.Labels); err != nil {
+		return nil, err
+	}
+	if st.annotations, err = parseMap("annotation", spec.Annotations); err != nil {
+		return nil, err
+	}
+	if st.data, err = parseMap("data", spec.Data); err != nil {
+		return nil, err
+	}
+
+	sample := secretTemplateVars{
+		serverVars: sampleServerVars(),
+		Server:     "https://example.com", Token: "token", Kubeconfig: "{}", KubeconfigYAML: "{}\n",
+	}
+	if _, err := st.render(sample, secretOutput{}); err != nil && !isMissingPathError(err) {
+		return nil, err
+	}
+	return st, nil
+}
+
+// render applies the template on top of base: name and type if templated, extra
+// labels and annotations, and data keys. Templated data replaces base data entirely.
+func (st *SecretTemplate) render(vars secretTemplateVars, base secretOutput) (secretOutput, error) {
+	exec := func(t *template.Template) (string, error) {
+		out, err := executeTemplate(t, vars)
+		if err != nil {
+			return "", fmt.Errorf("secret template %s: %w", t.Name(), err)
+		}
+		return out, nil
+	}
+
+	out := base
+	if st.name != nil {
+		n, err := exec(st.name)
+		if err != nil {
+			return out, err
+		}
+		out.Name = strings.TrimSpace(n)
+		if errs := validation.IsDNS1123Subdomain(out.Name); len(errs) > 0 {
+			return out, fmt.Errorf("secret template name %q: %s", out.Name, strings.Join(errs, "; "))
+		}
+	}
+	if st.typ != "" {
+		out.Type = corev1.SecretType(st.typ)
+	}
+
+	lbl := map[string]string{}
+	for k, v := range base.Labels {
+		lbl[k] = v
+	}
+	for k, t := range st.labels {
+		v, err := exec(t)
+		if err != nil {
+			return out, err
+		}
+		v = strings.TrimSpace(v)
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return out, fmt.Errorf("secret template label %s=%q: %s", k, v, strings.Join(errs, "; "))
+		}
+		lbl[k] = v
+	}
+	out.Labels = lbl
+
+	if len(st.annotations) > 0 {
+		out.Annotations = map[string]string{}
+		for k, t := range st.annotations {
+			v, err := exec(t)
+			if err != nil {
+				return out, err
+			}
+			out.Annotations[k] = v
+		}
+	}
+
+	if len(st.data) > 0 {
+		out.KubeconfigKey = st.kcfgKey
+		out.Data = make(map[string][]byte, len(st.data))
+		for k, t := range st.data {
+			v, err := exec(t)
+			if err != nil {
+				return out, err
+			}
+			out.Data[k] = []byte(v)
+		}
+	}
+	return out, nil
+}
+
+// secretTemplateVarsFor builds the template context for one publish.
+func (r *VciReconciler) secretTemplateVarsFor(in *publishInput) (secretTemplateVars, error) {
+	y, err := yaml.JSONToYAML(in.KcfgJSON)
+	if err != nil {
+		return secretTemplateVars{}, fmt.Errorf("encode kubeconfig as yaml: %w", err)
+	}
+	return secretTemplateVars{
+		serverVars:     r.serverVarsFor(in.VCI),
+		Server:         in.Server,
+		Token:          in.Token,
+		CA:             string(in.CAPEM),
+		Kubeconfig:     string(in.KcfgJSON),
+		KubeconfigYAML: string(y),
+	}, nil
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/loft-demos/vcluster-platform-flux-secret-controller/internal/util"
+)
+
+// serverVars is the template context for --server-template.
+type serverVars struct {
+	Domain      string
+	Project     string
+	Namespace   string
+	Name        string
+	Cluster     string            // spec.clusterRef.cluster, the connected cluster the VCI runs on
+	Labels      map[string]string // VCI labels
+	Annotations map[string]string // VCI annotations
+	Spec        map[string]any    // VCI spec, e.g. {{ .Spec.clusterRef.namespace }}
+}
+
+// serverTemplateFuncs is the function library available to --server-template.
+// Argument order follows sprig so values can be piped: {{ .Labels.region | default "us" | lower }}.
+var serverTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"default": func(def string, v any) string {
+		if v == nil {
+			return def
+		}
+		if s := fmt.Sprint(v); s != "" {
+			return s
+		}
+		return def
+	},
+}
+
+// sampleServerVars is the context templates are rendered against at startup.
+// Spec holds the fields a platform VCI normally has, so templates using them
+// (e.g. {{ .Spec.clusterRef.namespace }}) can be checked too.
+func sampleServerVars() serverVars {
+	return serverVars{
+		Domain: "example.com", Project: "default", Namespace: "p-default", Name: "vcluster", Cluster: "loft-cluster",
+		Labels: map[string]string{}, Annotations: map[string]string{},
+		Spec: map[string]any{
+			"displayName": "vcluster",
+			"description": "",
+			"owner":       map[string]any{"user": "admin"},
+			"clusterRef":  map[string]any{"cluster": "loft-cluster", "namespace": "loft-default-v-vcluster", "virtualCluster": "vcluster"},
+			"templateRef": map[string]any{"name": "default", "version": ""},
+			"template":    map[string]any{"metadata": map[string]any{"labels": map[string]any{}, "annotations": map[string]any{}}},
+			"parameters":  "",
+		},
+	}
+}
+
+// executeTemplate renders t and rejects output containing "<no value>", which
+// text/template prints for a key missing from a map[string]any such as .Spec.
+func executeTemplate(t *template.Template, vars any) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	if bytes.Contains(buf.Bytes(), []byte("<no value>")) {
+		return "", fmt.Errorf("template %s references a missing value", t.Name())
+	}
+	return buf.String(), nil
+}
+
+// isMissingPathError reports whether a sample render failed only because a
+// nested field the sample context does not have was dereferenced, e.g. a
+// .Spec path specific to the user's VCIs. Such templates are checked at reconcile.
+func isMissingPathError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "nil pointer evaluating")
+}
+
+// ParseServerTemplate parses --server-template once and renders it against
+// sample values, so syntax errors, unknown functions and templates that don't
+// yield an absolute URL fail at startup rather than on every reconcile.
+func ParseServerTemplate(s string) (*template.Template, error) {
+	tmpl, err := template.New("server").Funcs(serverTemplateFuncs).Option("missingkey=zero").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("parse server template: %w", err)
+	}
+	out, err := renderServerURL(tmpl, sampleServerVars())
+	if isMissingPathError(err) {
+		return tmpl, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("render server template: %w", err)
+	}
+	if u, err := url.Parse(out); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("server template renders %q for sample values, want an absolute URL", out)
+	}
+	return tmpl, nil
+}
+
+func renderServerURL(tmpl *template.Template, vars serverVars) (string, error) {
+	out, err := executeTemplate(tmpl, vars)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// serverVarsFor builds the --server-template context for a VCI.
+func (r *VciReconciler) serverVarsFor(vci *unstructured.Unstructured) serverVars {
+	spec, _, _ := unstructured.NestedMap(vci.Object, "spec")
+	if spec == nil {
+		spec = map[string]any{}
+	}
+	lbls, anns := vci.GetLabels(), vci.GetAnnotations()
+	if lbls == nil {
+		lbls = map[string]string{}
+	}
+	if anns == nil {
+		anns = map[string]string{}
+	}
+	return serverVars{
+		Domain:      r.Opts.LoftDomain,
+		Project:     projectFromNamespace(vci.GetNamespace()),
+		Namespace:   vci.GetNamespace(),
+		Name:        vci.GetName(),
+		Cluster:     util.GetString(vci, "spec", "clusterRef", "cluster"),
+		Labels:      lbls,
+		Annotations: anns,
+		Spec:        spec,
+	}
+}
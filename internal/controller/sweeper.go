@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// SetupOrphanSweeper registers a leader-elected Runnable that, once caches have
+// synced, deletes managed AccessKeys, Secrets, bootstrap objects and input
+// providers whose VCI no longer exists or no longer matches --selector. With interval > 0 the sweep repeats periodically;
+// otherwise it runs once at startup.
+func (r *VciReconciler) SetupOrphanSweeper(mgr ctrl.Manager, interval time.Duration) error {
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return fmt.Errorf("orphan sweep: caches did not sync")
+		}
+		r.sweepOrphans(ctx)
+		if interval <= 0 {
+			return nil
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-t.C:
+				r.sweepOrphans(ctx)
+			}
+		}
+	}))
+}
+
+func (r *VciReconciler) sweepOrphans(ctx context.Context) {
+	log := r.Log.WithName("orphan-sweep")
+	managed := client.MatchingLabels{"app.kubernetes.io/managed-by": "vcluster-platform-flux-secret-controller"}
+
+	// cache liveness per VCI so we hit the API once per owner
+	live := map[types.NamespacedName]bool{}
+	isLive := func(nn types.NamespacedName) (bool, error) {
+		if v, ok := live[nn]; ok {
+			return v, nil
+		}
+		v, err := r.vciIsLive(ctx, nn)
+		if err != nil {
+			return false, err
+		}
+		live[nn] = v
+		return v, nil
+	}
+
+	// Flux objects first: a VCI's Secrets are left for the next sweep while its
+	// bootstrap objects still exist, as their finalizers need the kubeconfig
+	var fluxObjs []client.Object
+	var fluxKinds []schema.GroupVersionKind
+	if len(r.Opts.BootstrapTemplates) > 0 {
+		fluxKinds = append(fluxKinds, bootstrapKinds...)
+	}
+	if r.Opts.InputProviders {
+		fluxKinds = append(fluxKinds, gvkInputProvider)
+	}
+	for _, gvk := range fluxKinds {
+		var list unstructured.UnstructuredList
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.List(ctx, &list, managed); err != nil {
+			log.Error(err, "failed to list "+gvk.Kind+"s")
+			continue
+		}
+		for i := range list.Items {
+			fluxObjs = append(fluxObjs, &list.Items[i])
+		}
+	}
+
+	var objs []client.Object
+
+	var aks unstructured.UnstructuredList
+	aks.SetGroupVersionKind(gvkAK.GroupVersion().WithKind(gvkAK.Kind + "List"))
+	if err := r.List(ctx, &aks, managed); err != nil {
+		log.Error(err, "failed to list AccessKeys")
+	} else {
+		for i := range aks.Items {
+			objs = append(objs, &aks.Items[i])
+		}
+	}
+
+	var secs corev1.SecretList
+	if err := r.List(ctx, &secs, managed); err != nil {
+		log.Error(err, "failed to list Secrets")
+	} else {
+		for i := range secs.Items {
+			objs = append(objs, &secs.Items[i])
+		}
+	}
+
+	fluxDeleted, akDeleted, secDeleted, failed := 0, 0, 0, 0
+	fluxPending := map[types.NamespacedName]bool{}
+	for _, o := range fluxObjs {
+		nn, ok := owningVCI(o)
+		if !ok {
+			continue
+		}
+		alive, err := isLive(nn)
+		if err != nil {
+			log.Error(err, "failed to look up VCI", "vci", nn.String())
+			failed++
+			continue
+		}
+		if alive {
+			continue
+		}
+		if ts := o.GetDeletionTimestamp(); ts != nil {
+			if time.Since(ts.Time) < bootstrapDeleteTimeout {
+				fluxPending[nn] = true
+			}
+			continue
+		}
+		fluxPending[nn] = true
+		if err := r.Delete(ctx, o); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "failed to delete orphan", "kind", o.GetObjectKind().GroupVersionKind().Kind,
+				"name", o.GetName(), "namespace", o.GetNamespace(), "vci", nn.String())
+			failed++
+			continue
+		}
+		fluxDeleted++
+	}
+
+	for _, o := range objs {
+		nn, ok := owningVCI(o)
+		if !ok {
+			continue
+		}
+		alive, err := isLive(nn)
+		if err != nil {
+			log.Error(err, "failed to look up VCI", "vci", nn.String())
+			failed++
+			continue
+		}
+		if alive || fluxPending[nn] {
+			continue
+		}
+		if err := r.Delete(ctx, o); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "failed to delete orphan", "name", o.GetName(), "namespace", o.GetNamespace(), "vci", nn.String())
+			failed++
+			continue
+		}
+		if _, isAK := o.(*unstructured.Unstructured); isAK {
+			akDeleted++
+		} else {
+			secDeleted++
+		}
+	}
+
+	log.Info("orphan sweep finished",
+		"fluxObjectsDeleted", fluxDeleted,
+		"accessKeysDeleted", akDeleted,
+		"secretsDeleted", secDeleted,
+		"failed", failed,
+	)
+}
+
+// vciIsLive reports whether the VCI exists, is not being deleted and still matches --selector.
+func (r *VciReconciler) vciIsLive(ctx context.Context, nn types.NamespacedName) (bool, error) {
+	var vci unstructured.Unstructured
+	vci.SetGroupVersionKind(gvkVCI)
+	if err := r.Get(ctx, nn, &vci); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return vci.GetDeletionTimestamp() == nil && r.matchesSelector(vci.GetLabels()), nil
+}
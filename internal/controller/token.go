@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Token Secret / AccessKey annotations used for rotation bookkeeping.
+const (
+	annTokenIssuedAt          = "vci.flux.loft.sh/token-issued-at"
+	annTokenRotatedAt         = "vci.flux.loft.sh/token-rotated-at"
+	annPreviousTokenExpiresAt = "vci.flux.loft.sh/previous-token-expires-at"
+	annAccessKeyExpiresAt     = "vci.flux.loft.sh/accesskey-expires-at"
+
+	// annTokenRotationInterval on a VCI overrides --token-rotation-interval ("0" disables).
+	annTokenRotationInterval = "vci.flux.loft.sh/token-rotation-interval"
+)
+
+// tokenRotationInterval returns the per-VCI override if set and valid, else the global interval.
+func (r *VciReconciler) tokenRotationInterval(vci *unstructured.Unstructured) time.Duration {
+	if v, ok := vci.GetAnnotations()[annTokenRotationInterval]; ok {
+		d, err := time.ParseDuration(v)
+		if err == nil && d >= 0 {
+			return d
+		}
+		r.Log.Info("ignoring invalid token rotation interval annotation",
+			"vci", vci.GetNamespace()+"/"+vci.GetName(), "value", v)
+	}
+	return r.Opts.TokenRotationInterval
+}
+
+// accessKeyRenewAfter is how long after issuance a TTL'd AccessKey is renewed
+// (0 if no TTL is configured).
+func (r *VciReconciler) accessKeyRenewAfter() time.Duration {
+	ttl := r.Opts.AccessKeyTTL
+	if ttl <= 0 {
+		return 0
+	}
+	if d := ttl - r.Opts.AccessKeyRenewBefore; d > 0 {
+		return d
+	}
+	return ttl / 2
+}
+
+// accessKeyNeedsRenewal reports whether an existing AccessKey carries a different
+// key or TTL than wanted.
+func accessKeyNeedsRenewal(ak *unstructured.Unstructured, token string, ttl time.Duration) bool {
+	key, _, _ := unstructured.NestedString(ak.Object, "spec", "key")
+	cur, _, _ := unstructured.NestedInt64(ak.Object, "spec", "ttl")
+	return key != token || cur != int64(ttl.Seconds())
+}
+
+// previousAccessKeyName names the AccessKey holding the pre-rotation token during the grace period.
+func previousAccessKeyName(project, vciName string) string {
+	return accessKeyName(project, vciName) + "-previous"
+}
+
+func (r *VciReconciler) deletePreviousAccessKey(ctx context.Context, project, vciName string) (bool, error) {
+	ak := unstructured.Unstructured{}
+	ak.SetGroupVersionKind(gvkAK)
+	ak.SetName(previousAccessKeyName(project, vciName))
+	err := r.Delete(ctx, &ak)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// parseTimeOr parses an RFC3339 timestamp, falling back to def.
+func parseTimeOr(v string, def time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+	return def
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/loft-demos/vcluster-platform-flux-secret-controller/internal/envelope"
+)
+
+// Token Secret data keys. Plaintext tokens live under "token"; with envelope
+// encryption enabled the token is stored sealed with its wrapped DEK instead.
+const (
+	tokenKeyPlain      = "token"
+	tokenKeyCiphertext = "token.enc"
+	tokenKeyWrappedDEK = "dek.wrapped"
+
+	// annTokenKEKID records which KEK wrapped the DEK.
+	annTokenKEKID = "vci.flux.loft.sh/kek-id"
+)
+
+// errTokenUnreadable means a stored token cannot be decrypted with the configured
+// keys, e.g. after the KEK was replaced without --token-encryption-previous-key-files.
+var errTokenUnreadable = errors.New("stored token cannot be decrypted")
+
+// readStoredToken returns the token held in a token Secret, decrypting it if needed.
+// An empty string means the Secret holds no token yet.
+func (r *VciReconciler) readStoredToken(ctx context.Context, s *corev1.Secret) (string, error) {
+	if b, ok := s.Data[tokenKeyPlain]; ok && len(b) > 0 {
+		return string(b), nil
+	}
+	ct, wrapped := s.Data[tokenKeyCiphertext], s.Data[tokenKeyWrappedDEK]
+	if len(ct) == 0 {
+		return "", nil
+	}
+	if r.Opts.TokenKeyProvider == nil {
+		return "", fmt.Errorf("%w: token Secret %s/%s is encrypted but no encryption key is configured", errTokenUnreadable, s.Namespace, s.Name)
+	}
+	pt, err := envelope.Open(ctx, r.Opts.TokenKeyProvider, ct, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("%w: token Secret %s/%s (kek %q): %v", errTokenUnreadable, s.Namespace, s.Name, s.Annotations[annTokenKEKID], err)
+	}
+	return string(pt), nil
+}
+
+// tokenSecretData returns the Secret data to store for token, plus the KEK id
+// when encrypted. Existing sealed data is reused if it already holds the same
+// token under the current KEK, so reconciles don't rewrite the Secret.
+func (r *VciReconciler) tokenSecretData(ctx context.Context, existing *corev1.Secret, token string) (map[string][]byte, string, error) {
+	kp := r.Opts.TokenKeyProvider
+	if kp == nil {
+		return map[string][]byte{tokenKeyPlain: []byte(token)}, "", nil
+	}
+	if existing != nil && existing.Annotations[annTokenKEKID] == kp.KeyID() && len(existing.Data[tokenKeyPlain]) == 0 {
+		if pt, err := envelope.Open(ctx, kp, existing.Data[tokenKeyCiphertext], existing.Data[tokenKeyWrappedDEK]); err == nil && bytes.Equal(pt, []byte(token)) {
+			return map[string][]byte{
+				tokenKeyCiphertext: existing.Data[tokenKeyCiphertext],
+				tokenKeyWrappedDEK: existing.Data[tokenKeyWrappedDEK],
+			}, kp.KeyID(), nil
+		}
+	}
+	ct, wrapped, err := envelope.Seal(ctx, kp, []byte(token))
+	if err != nil {
+		return nil, "", fmt.Errorf("encrypt token: %w", err)
+	}
+	return map[string][]byte{
+		tokenKeyCiphertext: ct,
+		tokenKeyWrappedDEK: wrapped,
+	}, kp.KeyID(), nil
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Verification modes for rendered kubeconfigs.
+const (
+	// VerifyOff publishes without checking (default).
+	VerifyOff = "off"
+	// VerifyLabel publishes always and sets vci.flux.loft.sh/verified=true|false.
+	VerifyLabel = "label"
+	// VerifyGate publishes only once the kubeconfig has been verified.
+	VerifyGate = "gate"
+
+	// annVerify on a VCI overrides --verify-mode.
+	annVerify = "vci.flux.loft.sh/verify"
+	// lblVerified on published Secrets records the verification result.
+	lblVerified = "vci.flux.loft.sh/verified"
+)
+
+// ValidVerifyMode reports whether m is one of off, label or gate.
+func ValidVerifyMode(m string) bool {
+	return m == VerifyOff || m == VerifyLabel || m == VerifyGate
+}
+
+// verifyModeFor returns the VCI's vci.flux.loft.sh/verify annotation, else the global mode.
+func (r *VciReconciler) verifyModeFor(vci *unstructured.Unstructured) (string, error) {
+	if v, ok := vci.GetAnnotations()[annVerify]; ok {
+		if !ValidVerifyMode(v) {
+			return "", fmt.Errorf("invalid %s annotation %q (want off|label|gate)", annVerify, v)
+		}
+		return v, nil
+	}
+	if r.Opts.VerifyMode == "" {
+		return VerifyOff, nil
+	}
+	return r.Opts.VerifyMode, nil
+}
+
+// verifyKubeconfig makes a single SelfSubjectReview call with the rendered
+// kubeconfig, so the token itself must be accepted: unlike /version, which is
+// public by default, it fails for anonymous or rejected credentials. A checksum
+// that verified before is not checked again; fresh is true only when this call
+// performed a successful check. Retries are driven by the caller's
+// requeue (see verifyBackoff), so a slow or unreachable server never holds a worker
+// for longer than --verify-timeout.
+func (r *VciReconciler) verifyKubeconfig(ctx context.Context, vci types.NamespacedName, kcfg []byte, sumHex string) (fresh bool, err error) {
+	if v, ok := r.verified.Load(vci); ok && v.(string) == sumHex {
+		return false, nil
+	}
+
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(kcfg)
+	if err != nil {
+		return false, fmt.Errorf("load kubeconfig: %w", err)
+	}
+	cfg.Timeout = r.Opts.VerifyTimeout
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return false, err
+	}
+	if err := checkAuthenticated(ctx, cs); err != nil {
+		r.verifyFailures.Store(vci, r.verifyFailureCount(vci)+1)
+		return false, err
+	}
+	r.verified.Store(vci, sumHex)
+	r.verifyFailures.Delete(vci)
+	return true, nil
+}
+
+// checkAuthenticated asks the server who the credentials belong to. Servers
+// without authentication.k8s.io/v1 SelfSubjectReview (Kubernetes < 1.28) get a
+// SelfSubjectAccessReview instead, which anonymous users cannot create either.
+func checkAuthenticated(ctx context.Context, cs kubernetes.Interface) error {
+	ssr, err := cs.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authnv1.SelfSubjectReview{}, meta.CreateOptions{})
+	switch {
+	case err == nil:
+		if u := ssr.Status.UserInfo.Username; u == "" || u == "system:anonymous" {
+			return fmt.Errorf("server treats the token as anonymous")
+		}
+		return nil
+	case apierrors.IsNotFound(err):
+		_, err = cs.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authzv1.SelfSubjectAccessReview{
+			Spec: authzv1.SelfSubjectAccessReviewSpec{
+				NonResourceAttributes: &authzv1.NonResourceAttributes{Path: "/version", Verb: "get"},
+			},
+		}, meta.CreateOptions{})
+		return err
+	default:
+		return err
+	}
+}
+
+func (r *VciReconciler) verifyFailureCount(vci types.NamespacedName) int {
+	if v, ok := r.verifyFailures.Load(vci); ok {
+		return v.(int)
+	}
+	return 0
+}
+
+// verifyBackoff is the requeue delay after consecutive failed verifications:
+// --verify-retry-after doubled per failure, capped at --verify-max-retry-after.
+func (r *VciReconciler) verifyBackoff(vci types.NamespacedName) time.Duration {
+	base := r.Opts.VerifyRetryAfter
+	if base <= 0 {
+		base = 10 * time.Second
+	}
+	maxDelay := r.Opts.VerifyMaxRetryAfter
+	if maxDelay < base {
+		maxDelay = 10 * time.Minute
+	}
+	d := base
+	for i := 1; i < r.verifyFailureCount(vci) && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	return d
+}
+
+// forgetVerification drops cached verification state for a revoked VCI.
+func (r *VciReconciler) forgetVerification(vci types.NamespacedName) {
+	r.verified.Delete(vci)
+	r.verifyFailures.Delete(vci)
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// selectedVCIs returns every VCI matching --selector.
+func (r *VciReconciler) selectedVCIs(ctx context.Context) ([]unstructured.Unstructured, error) {
+	var list unstructured.UnstructuredList
+	list.SetGroupVersionKind(gvkVCI.GroupVersion().WithKind(gvkVCI.Kind + "List"))
+
+	opts := &client.ListOptions{}
+	if r.Opts.LabelSelector != "" {
+		if sel, err := labels.Parse(r.Opts.LabelSelector); err == nil {
+			opts.LabelSelector = sel
+		}
+	}
+	if err := r.List(ctx, &list, opts); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// listSelectedVCIs returns requests for every VCI matching --selector.
+func (r *VciReconciler) listSelectedVCIs(ctx context.Context) ([]reconcile.Request, error) {
+	vcis, err := r.selectedVCIs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]reconcile.Request, 0, len(vcis))
+	for _, vci := range vcis {
+		out = append(out, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: vci.GetNamespace(),
+			Name:      vci.GetName(),
+		}})
+	}
+	return out, nil
+}
+
+// mapNamespaceToVCIs fans an output (Flux, Argo CD, ...) namespace event out to all
+// selected VCIs so the namespace receives its Secrets without waiting for a VCI change.
+func (r *VciReconciler) mapNamespaceToVCIs(ctx context.Context, o client.Object) []reconcile.Request {
+	if !r.matchesOutputNamespace(o.GetName()) {
+		return nil
+	}
+	reqs, err := r.listSelectedVCIs(ctx)
+	if err != nil {
+		crlog.FromContext(ctx).Error(err, "failed to list VCIs for namespace event", "namespace", o.GetName())
+		return nil
+	}
+	return reqs
+}
+
+// namespaceEventPredicate passes namespace creates and label changes only.
+func namespaceEventPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return true },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			return !equalStringMap(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// managedByPredicate passes only objects labelled as managed by this controller.
+func managedByPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetLabels()["app.kubernetes.io/managed-by"] == "vcluster-platform-flux-secret-controller"
+	})
+}
+
+// mapManagedObjectToVCI resolves the owning VCI of a managed Secret or AccessKey
+// so out-of-band edits or deletes are repaired by a regular reconcile.
+func (r *VciReconciler) mapManagedObjectToVCI(_ context.Context, o client.Object) []reconcile.Request {
+	if nn, ok := owningVCI(o); ok {
+		return []reconcile.Request{{NamespacedName: nn}}
+	}
+	return nil
+}
+
+// owningVCI reads the VCI reference from the labels/annotation we stamp on
+// Flux Secrets (vci.flux.loft.sh/*), AccessKeys (loft.sh/vcluster-instance-*)
+// and token Secrets (vci.flux.loft.sh/vci annotation).
+func owningVCI(o client.Object) (types.NamespacedName, bool) {
+	lbl := o.GetLabels()
+	if n, ns := lbl["vci.flux.loft.sh/name"], lbl["vci.flux.loft.sh/namespace"]; n != "" && ns != "" {
+		return types.NamespacedName{Namespace: ns, Name: n}, true
+	}
+	if n, ns := lbl["loft.sh/vcluster-instance-name"], lbl["loft.sh/vcluster-instance-namespace"]; n != "" && ns != "" {
+		return types.NamespacedName{Namespace: ns, Name: n}, true
+	}
+	if ref := o.GetAnnotations()["vci.flux.loft.sh/vci"]; ref != "" {
+		if ns, n, ok := strings.Cut(ref, "/"); ok && ns != "" && n != "" {
+			return types.NamespacedName{Namespace: ns, Name: n}, true
+		}
+	}
+	return types.NamespacedName{}, false
+}
+
+// ownedBy reports whether o carries our ownership labels for the given VCI.
+func ownedBy(o client.Object, vciNamespace, vciName string) bool {
+	lbl := o.GetLabels()
+	return lbl["app.kubernetes.io/managed-by"] == "vcluster-platform-flux-secret-controller" &&
+		lbl["vci.flux.loft.sh/name"] == vciName &&
+		lbl["vci.flux.loft.sh/namespace"] == vciNamespace
+}
+
+// caSecretPredicate passes events for the configured CA Secret only; updates
+// must change its data.
+func (r *VciReconciler) caSecretPredicate() predicate.Predicate {
+	isCA := func(o client.Object) bool {
+		return o != nil && r.Opts.CASecretName != "" &&
+			o.GetNamespace() == r.Opts.CASecretNS && o.GetName() == r.Opts.CASecretName
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return isCA(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !isCA(e.ObjectNew) {
+				return false
+			}
+			oldS, ok1 := e.ObjectOld.(*corev1.Secret)
+			newS, ok2 := e.ObjectNew.(*corev1.Secret)
+			return !ok1 || !ok2 || !reflect.DeepEqual(oldS.Data, newS.Data)
+		},
+		DeleteFunc:  func(e event.DeleteEvent) bool { return isCA(e.Object) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// mapCASecretToVCIs re-renders every selected VCI's kubeconfig after a CA rotation.
+func (r *VciReconciler) mapCASecretToVCIs(ctx context.Context, o client.Object) []reconcile.Request {
+	reqs, err := r.listSelectedVCIs(ctx)
+	if err != nil {
+		crlog.FromContext(ctx).Error(err, "failed to list VCIs for CA secret event", "secret", client.ObjectKeyFromObject(o))
+		return nil
+	}
+	return reqs
+}
+
+// loadBalancerServicePredicate passes LoadBalancer Services whose ingress
+// addresses change, i.e. once a vCluster's direct endpoint gets an address.
+func loadBalancerServicePredicate() predicate.Predicate {
+	isLB := func(o client.Object) bool {
+		svc, ok := o.(*corev1.Service)
+		return ok && svc.Spec.Type == corev1.ServiceTypeLoadBalancer
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return isLB(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldS, ok1 := e.ObjectOld.(*corev1.Service)
+			newS, ok2 := e.ObjectNew.(*corev1.Service)
+			if !ok1 || !ok2 || (!isLB(oldS) && !isLB(newS)) {
+				return false
+			}
+			return oldS.Spec.Type != newS.Spec.Type ||
+				!reflect.DeepEqual(oldS.Status.LoadBalancer, newS.Status.LoadBalancer) ||
+				!reflect.DeepEqual(oldS.Spec.Ports, newS.Spec.Ports)
+		},
+		DeleteFunc:  func(e event.DeleteEvent) bool { return isLB(e.Object) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// mapServiceToVCIs requeues the direct-mode VCIs whose vCluster Service is o.
+func (r *VciReconciler) mapServiceToVCIs(ctx context.Context, o client.Object) []reconcile.Request {
+	vcis, err := r.selectedVCIs(ctx)
+	if err != nil {
+		crlog.FromContext(ctx).Error(err, "failed to list VCIs for service event", "service", client.ObjectKeyFromObject(o))
+		return nil
+	}
+	var out []reconcile.Request
+	for i := range vcis {
+		if mode, err := r.endpointModeFor(&vcis[i]); err != nil || mode != EndpointModeDirect {
+			continue
+		}
+		if t := vcTargetFor(&vcis[i]); t.HostNamespace == o.GetNamespace() && t.Name == o.GetName() {
+			out = append(out, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&vcis[i])})
+		}
+	}
+	return out
+}
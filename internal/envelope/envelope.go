@@ -0,0 +1,83 @@
+// Package envelope implements envelope encryption for small secrets: each value
+// is sealed with a fresh data encryption key (DEK), and the DEK is wrapped by a
+// KeyProvider holding the key encryption key (KEK).
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeyProvider wraps and unwraps DEKs. Implementations may hold the KEK locally
+// (see FileKeyProvider) or delegate to a KMS plugin.
+type KeyProvider interface {
+	// KeyID identifies the KEK so stored values can be matched to it.
+	KeyID() string
+	WrapKey(ctx context.Context, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Seal encrypts plaintext with a new 256-bit DEK and returns the ciphertext
+// together with the DEK wrapped by kp.
+func Seal(ctx context.Context, kp KeyProvider, plaintext []byte) (ciphertext, wrappedDEK []byte, err error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err = aesGCMSeal(dek, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrappedDEK, err = kp.WrapKey(ctx, dek)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrap DEK: %w", err)
+	}
+	return ciphertext, wrappedDEK, nil
+}
+
+// Open reverses Seal.
+func Open(ctx context.Context, kp KeyProvider, ciphertext, wrappedDEK []byte) ([]byte, error) {
+	dek, err := kp.UnwrapKey(ctx, wrappedDEK)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap DEK: %w", err)
+	}
+	return aesGCMOpen(dek, ciphertext)
+}
+
+// aesGCMSeal returns nonce||ciphertext.
+func aesGCMSeal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func aesGCMOpen(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,167 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newKEK(t *testing.T) []byte {
+	t.Helper()
+	kek := make([]byte, 32)
+	if _, err := rand.Read(kek); err != nil {
+		t.Fatal(err)
+	}
+	return kek
+}
+
+func writeKeyFile(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newProvider(t *testing.T, kek []byte) *FileKeyProvider {
+	t.Helper()
+	kp, err := NewFileKeyProvider(writeKeyFile(t, kek))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	kp := newProvider(t, newKEK(t))
+	plaintext := []byte("access-key-token")
+
+	ct, wrapped, err := Seal(ctx, kp, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ct, plaintext) {
+		t.Fatal("ciphertext contains the plaintext")
+	}
+	got, err := Open(ctx, kp, ct, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("Open() = %q, want %q", got, plaintext)
+	}
+}
+
+func TestOpenWrongKEK(t *testing.T) {
+	ctx := context.Background()
+	ct, wrapped, err := Seal(ctx, newProvider(t, newKEK(t)), []byte("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(ctx, newProvider(t, newKEK(t)), ct, wrapped); err == nil {
+		t.Fatal("Open() with a different KEK succeeded")
+	}
+}
+
+func TestOpenTamperedCiphertext(t *testing.T) {
+	ctx := context.Background()
+	kp := newProvider(t, newKEK(t))
+	ct, wrapped, err := Seal(ctx, kp, []byte("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct[len(ct)-1] ^= 1
+	if _, err := Open(ctx, kp, ct, wrapped); err == nil {
+		t.Fatal("Open() of tampered ciphertext succeeded")
+	}
+}
+
+func TestNewFileKeyProvider(t *testing.T) {
+	kek := newKEK(t)
+	b64 := base64.StdEncoding.EncodeToString(kek)
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{name: "raw", content: kek},
+		{name: "base64", content: []byte(b64)},
+		{name: "base64 with newline", content: []byte(b64 + "\n")},
+		{name: "too short", content: kek[:16], wantErr: true},
+		{name: "base64 of wrong length", content: []byte(base64.StdEncoding.EncodeToString(kek[:16])), wantErr: true},
+	}
+	var ids []string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kp, err := NewFileKeyProvider(writeKeyFile(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, kp.KeyID())
+		})
+	}
+	// raw and base64 encodings of the same key are the same KEK
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("key ids differ across encodings: %v", ids)
+		}
+	}
+}
+
+func TestNewFileKeyProviderMissingFile(t *testing.T) {
+	if _, err := NewFileKeyProvider(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	ctx := context.Background()
+	oldKP := newProvider(t, newKEK(t))
+	newKP := newProvider(t, newKEK(t))
+
+	ct, wrapped, err := Seal(ctx, oldKP, []byte("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring := NewKeyRing(newKP, oldKP)
+	if ring.KeyID() != newKP.KeyID() {
+		t.Fatalf("KeyID() = %q, want the primary's %q", ring.KeyID(), newKP.KeyID())
+	}
+	got, err := Open(ctx, ring, ct, wrapped)
+	if err != nil {
+		t.Fatalf("Open() with previous KEK: %v", err)
+	}
+	if string(got) != "token" {
+		t.Fatalf("Open() = %q", got)
+	}
+
+	// re-sealed values are wrapped by the primary only
+	ct, wrapped, err = Seal(ctx, ring, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(ctx, newKP, ct, wrapped); err != nil {
+		t.Fatalf("Open() with primary KEK: %v", err)
+	}
+	if _, err := Open(ctx, oldKP, ct, wrapped); err == nil {
+		t.Fatal("re-sealed value opened with the previous KEK")
+	}
+
+	if _, err := Open(ctx, NewKeyRing(newKP), ct[:0], wrapped[:0]); err == nil {
+		t.Fatal("Open() of empty input succeeded")
+	}
+}
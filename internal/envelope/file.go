@@ -0,0 +1,47 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// FileKeyProvider holds a 256-bit KEK loaded from a file, e.g. a mounted Secret.
+// It is meant for small installs and testing; production setups can plug in a
+// KMS-backed KeyProvider instead.
+type FileKeyProvider struct {
+	kek []byte
+	id  string
+}
+
+// NewFileKeyProvider reads a KEK from path. The file holds either 32 raw bytes
+// or their base64 encoding.
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kek := b
+	if len(kek) != 32 {
+		dec, derr := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+		if derr != nil || len(dec) != 32 {
+			return nil, fmt.Errorf("key file %s: want 32 bytes raw or base64-encoded", path)
+		}
+		kek = dec
+	}
+	sum := sha256.Sum256(kek)
+	return &FileKeyProvider{kek: kek, id: fmt.Sprintf("file:%x", sum[:8])}, nil
+}
+
+func (p *FileKeyProvider) KeyID() string { return p.id }
+
+func (p *FileKeyProvider) WrapKey(_ context.Context, dek []byte) ([]byte, error) {
+	return aesGCMSeal(p.kek, dek)
+}
+
+func (p *FileKeyProvider) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	return aesGCMOpen(p.kek, wrapped)
+}
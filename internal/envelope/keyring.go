@@ -0,0 +1,44 @@
+package envelope
+
+import (
+	"context"
+	"errors"
+)
+
+// KeyRing wraps with its primary KeyProvider and unwraps with the primary or any
+// previous one, so a KEK can be rotated without losing existing values. Values
+// are re-wrapped under the primary the next time they are sealed.
+type KeyRing struct {
+	primary  KeyProvider
+	previous []KeyProvider
+}
+
+// NewKeyRing returns a KeyRing writing with primary and also reading previous.
+func NewKeyRing(primary KeyProvider, previous ...KeyProvider) *KeyRing {
+	return &KeyRing{primary: primary, previous: previous}
+}
+
+// KeyID is the primary KEK's id.
+func (k *KeyRing) KeyID() string { return k.primary.KeyID() }
+
+func (k *KeyRing) WrapKey(ctx context.Context, dek []byte) ([]byte, error) {
+	return k.primary.WrapKey(ctx, dek)
+}
+
+// UnwrapKey tries the primary, then each previous KEK. AES-GCM authenticates the
+// wrapped DEK, so a wrong KEK fails instead of returning garbage.
+func (k *KeyRing) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	dek, err := k.primary.UnwrapKey(ctx, wrapped)
+	if err == nil {
+		return dek, nil
+	}
+	errs := []error{err}
+	for _, p := range k.previous {
+		dek, err := p.UnwrapKey(ctx, wrapped)
+		if err == nil {
+			return dek, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}